@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/tldr-it-stepankutaj/openvpn-client/internal/api"
+	"github.com/tldr-it-stepankutaj/openvpn-client/internal/logger"
+	"github.com/tldr-it-stepankutaj/openvpn-client/internal/utils"
+)
+
+// clientConfig is the per-client configuration passed back to OpenVPN
+type clientConfig struct {
+	content         string
+	vpnIP           string
+	networks        []string
+	hasDefaultRoute bool
+	inactive        int // routes outside their schedule
+}
+
+// buildConfig builds the client config for the user and routes at now
+func buildConfig(log *logger.Logger, user *api.UserResponse, routes []api.Network, remoteIP string, now time.Time) *clientConfig {
+	// Build config file content
+	var configContent strings.Builder
+	result := &clientConfig{vpnIP: remoteIP}
+
+	// Set static VPN IP if configured
+	if user.VpnIP != "" {
+		result.vpnIP = user.VpnIP
+		configContent.WriteString(fmt.Sprintf("ifconfig-push %s 255.255.255.0\n", user.VpnIP))
+	}
+
+	// End the session when the user expires
+	if remaining, ok := user.RemainingValidity(now); ok {
+		seconds := max(int64(remaining/time.Second), 1)
+		configContent.WriteString(fmt.Sprintf("session-timeout %d\n", seconds))
+	}
+
+	// Check for default route and collect networks
+	for _, route := range routes {
+		// Push only routes whose schedule allows them right now
+		if route.Schedule != nil {
+			active, err := route.Schedule.ActiveAt(now)
+			if err != nil {
+				log.Warn("invalid route schedule, skipping", "cidr", route.CIDR, "error", err)
+				continue
+			}
+			if !active {
+				result.inactive++
+				continue
+			}
+		}
+		if utils.IsDefaultRoute(route.CIDR) {
+			result.hasDefaultRoute = true
+			continue
+		}
+		result.networks = append(result.networks, route.CIDR)
+	}
+
+	// Push routes
+	if result.hasDefaultRoute {
+		configContent.WriteString("push \"redirect-gateway def1\"\n")
+	} else {
+		for _, cidr := range result.networks {
+			route, err := utils.CIDRToNetmask(cidr)
+			if err != nil {
+				log.Warn("invalid CIDR, skipping", "cidr", cidr, "error", err)
+				continue
+			}
+			configContent.WriteString(fmt.Sprintf("push \"route %s\"\n", route))
+		}
+	}
+
+	result.content = configContent.String()
+	return result
+}
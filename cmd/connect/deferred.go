@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/tldr-it-stepankutaj/openvpn-client/internal/access"
+	"github.com/tldr-it-stepankutaj/openvpn-client/internal/cache"
+	"github.com/tldr-it-stepankutaj/openvpn-client/internal/config"
+	"github.com/tldr-it-stepankutaj/openvpn-client/internal/deferred"
+	"github.com/tldr-it-stepankutaj/openvpn-client/internal/logger"
+	"github.com/tldr-it-stepankutaj/openvpn-client/internal/session"
+)
+
+// startWorker defers the client-connect to a detached worker and exits. If
+// the worker cannot be started, the connect runs in the hook and the result
+// is still reported through client_connect_deferred_file.
+func startWorker(log *logger.Logger, cfg *config.Config, dc *deferred.Connect, configPath, openvpnConfigFile string, info *clientInfo) {
+	if err := dc.Defer(); err != nil {
+		log.Warn("deferred client-connect unavailable, connecting synchronously", "error", err)
+		return
+	}
+
+	args := []string{"-worker"}
+	if configPath != "" {
+		args = append(args, "-config", configPath)
+	}
+	args = append(args, openvpnConfigFile)
+
+	if err := deferred.Spawn(nil, args...); err != nil {
+		log.Warn("could not start deferred client-connect worker, connecting in the hook", "error", err)
+		runWorker(log, cfg, info)
+	}
+	os.Exit(0)
+}
+
+// runWorker runs a deferred client-connect and reports the result through
+// client_connect_deferred_file. When the API has not answered by the
+// deadline, the client is configured from the local caches or denied.
+// Whichever of the two paths claims the result first reports it; the other
+// one never writes a result.
+func runWorker(log *logger.Logger, cfg *config.Config, info *clientInfo) {
+	dc := deferred.ConnectFromEnv()
+	if dc == nil {
+		log.Error("client_connect_deferred_file not provided")
+		os.Exit(1)
+	}
+
+	var once sync.Once
+	claim := func() bool {
+		claimed := false
+		once.Do(func() { claimed = true })
+		return claimed
+	}
+	// lost parks the API path once the deadline path owns the result; that
+	// path exits the process
+	lost := func() {
+		select {}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	timeout := cfg.OpenVPN.DeferredConnect.Timeout
+	time.AfterFunc(timeout, func() {
+		if !claim() {
+			return
+		}
+		// Abort the API calls, which may hold the session lock
+		cancel()
+		fallbackAndExit(log, cfg, dc, info, timeout)
+	})
+	defer func() {
+		if r := recover(); r != nil {
+			log.Error("deferred client-connect failed", "panic", r)
+			if !claim() {
+				lost()
+			}
+			finish(log, dc, nil, false)
+			os.Exit(1)
+		}
+	}()
+
+	deliver := func(content []byte) error {
+		if !claim() {
+			lost()
+		}
+		return dc.Finish(content, true)
+	}
+	code := connect(ctx, log, cfg, info, deliver)
+	if code != 0 {
+		if !claim() {
+			lost()
+		}
+		finish(log, dc, nil, false)
+	}
+	os.Exit(code)
+}
+
+// fallbackAndExit reports the deadline result and exits. The session lock
+// is held until the process exits, so the cancelled API path cannot remove
+// the connection record afterwards.
+func fallbackAndExit(log *logger.Logger, cfg *config.Config, dc *deferred.Connect, info *clientInfo, timeout time.Duration) {
+	store := session.NewStore(cfg.OpenVPN.SessionDir)
+	unlock, err := store.Lock(info.commonName)
+	if err != nil {
+		log.Error("deferred client-connect fallback failed", "error", err)
+		finish(log, dc, nil, false)
+		os.Exit(1)
+	}
+	// os.Exit skips the deferred unlock; the exit releases the lock
+	defer unlock()
+
+	content, err := fallback(log, cfg, store, info)
+	allow := err == nil
+	if ferr := dc.Finish(content, allow); ferr != nil {
+		log.Error("failed to write deferred client-connect result", "error", ferr)
+		allow = false
+	}
+
+	if !allow {
+		if err == nil {
+			_ = store.RemoveConnection(info.commonName, info.trustedIP, info.trustedPort)
+		}
+		log.Warn("connection rejected", "reason", "deferred_timeout", "timeout", timeout.String(), "error", err)
+		os.Exit(1)
+	}
+	log.Warn("client connected from cache", "reason", "deferred_timeout", "timeout", timeout.String())
+	os.Exit(0)
+}
+
+// fallback builds the client config from the cached user and routes
+// (openvpn.deferred_connect.fallback: cache) and records the connection.
+// The same local checks as in connect apply: active account, validity
+// window, source rules and the local session count. Group source rules
+// need the API, so clients are refused when they are enabled. The caller
+// holds the session lock.
+func fallback(log *logger.Logger, cfg *config.Config, store *session.Store, info *clientInfo) ([]byte, error) {
+	if cfg.OpenVPN.DeferredConnect.Fallback != "cache" {
+		return nil, fmt.Errorf("no result before the deadline")
+	}
+
+	user, ok := cache.NewUserCache(cfg.OpenVPN.SessionDir, cfg.OpenVPN.UserCacheTTL).Get(info.commonName)
+	if !ok {
+		return nil, fmt.Errorf("user not cached")
+	}
+	routes, ok := cache.NewRouteCache(cfg.OpenVPN.SessionDir, cfg.OpenVPN.UserCacheTTL).Get(info.commonName)
+	if !ok {
+		return nil, fmt.Errorf("routes not cached")
+	}
+
+	if !user.IsActive {
+		return nil, fmt.Errorf("user is not active")
+	}
+	now := time.Now()
+	if err := user.CheckValidity(now, cfg.OpenVPN.Skew()); err != nil {
+		return nil, err
+	}
+
+	source, _ := access.SourceFromEnv("trusted_ip")
+	if source.IsValid() {
+		if _, err := access.CheckBlocklist(cfg.OpenVPN.SourceFilter.BlocklistFile, source); err != nil {
+			return nil, err
+		}
+		if cfg.OpenVPN.SourceFilter.GroupRules {
+			return nil, fmt.Errorf("group source rules need the API")
+		}
+		rules := []access.SourceRules{{Origin: "user", Allow: user.SourceAllow, Deny: user.SourceDeny}}
+		if err := access.CheckSource(source, rules); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := enforceSessionLimit(context.Background(), log, cfg, nil, store, user, info); err != nil {
+		return nil, err
+	}
+	recordConnection(log, store, info, now)
+
+	content := buildConfig(log, user, routes, info.remoteIP, now).content
+	return []byte(content + pushAuthToken(log, cfg, info.commonName, user, now)), nil
+}
+
+// denyAndExit reports a deny for a worker that cannot connect the client
+func denyAndExit(log *logger.Logger) {
+	if dc := deferred.ConnectFromEnv(); dc != nil {
+		finish(log, dc, nil, false)
+	}
+	os.Exit(1)
+}
+
+func finish(log *logger.Logger, dc *deferred.Connect, content []byte, allow bool) {
+	if err := dc.Finish(content, allow); err != nil && !errors.Is(err, deferred.ErrFinished) {
+		log.Error("failed to write deferred client-connect result", "error", err)
+	}
+}
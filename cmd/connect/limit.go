@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/tldr-it-stepankutaj/openvpn-client/internal/api"
+	"github.com/tldr-it-stepankutaj/openvpn-client/internal/config"
+	"github.com/tldr-it-stepankutaj/openvpn-client/internal/logger"
+	"github.com/tldr-it-stepankutaj/openvpn-client/internal/management"
+	"github.com/tldr-it-stepankutaj/openvpn-client/internal/session"
+)
+
+// errSessionLimit is returned when a user has reached their session limit
+var errSessionLimit = errors.New("concurrent session limit reached")
+
+// admitConnection checks the user's concurrent sessions and records the new
+// connection. Sessions are counted from the local session store and, when
+// available, the API's active sessions. With the kill_oldest policy, the
+// oldest local connections are disconnected through the management
+// interface to make room. Counting and recording happen under the store
+// lock of the common name, so concurrent connects cannot all pass the
+// limit; a connection refused afterwards must be forgotten again.
+func admitConnection(ctx context.Context, log *logger.Logger, cfg *config.Config, client *api.Client, store *session.Store, user *api.UserResponse, info *clientInfo, now time.Time) error {
+	unlock, err := store.Lock(info.commonName)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if err := enforceSessionLimit(ctx, log, cfg, client, store, user, info); err != nil {
+		return err
+	}
+	recordConnection(log, store, info, now)
+	return nil
+}
+
+// enforceSessionLimit makes room for the new connection or returns
+// errSessionLimit. The caller holds the store lock. Without a client, only
+// the local sessions are counted (deferred connect fallback).
+func enforceSessionLimit(ctx context.Context, log *logger.Logger, cfg *config.Config, client *api.Client, store *session.Store, user *api.UserResponse, info *clientInfo) error {
+	limitCfg := &cfg.OpenVPN.SessionLimit
+	limit := limitCfg.Limit(user.Role)
+	if limit <= 0 {
+		return nil
+	}
+
+	conns, err := liveConnections(store, info)
+	if err != nil {
+		return fmt.Errorf("failed to read local sessions: %w", err)
+	}
+
+	active := len(conns)
+	if client != nil {
+		sessions, ok, err := client.GetActiveSessions(ctx, user.ID)
+		if err != nil {
+			log.Warn("could not get active sessions, using local sessions only", "error", err)
+		} else if ok {
+			active = max(active, len(sessions))
+		}
+	}
+
+	if active < limit {
+		return nil
+	}
+
+	// Sessions on other servers cannot be disconnected from here
+	excess := active - limit + 1
+	if limitCfg.Policy != "kill_oldest" || excess > len(conns) {
+		return fmt.Errorf("%w (%d active, limit %d)", errSessionLimit, active, limit)
+	}
+
+	addresses := make([]string, 0, excess)
+	for _, conn := range conns[:excess] {
+		addresses = append(addresses, conn.RealAddress())
+	}
+	if err := killConnections(ctx, log, cfg, info, addresses); err != nil {
+		return fmt.Errorf("failed to disconnect oldest sessions: %w", err)
+	}
+
+	// Free the slots right away; the disconnect hooks remove them again
+	for _, conn := range conns[:excess] {
+		_ = store.RemoveConnection(conn.CommonName, conn.TrustedIP, conn.TrustedPort)
+	}
+
+	log.Info("disconnecting oldest sessions to stay within limit",
+		"client_addresses", addresses,
+		"limit", limit,
+	)
+	return nil
+}
+
+// killConnections disconnects the clients at addresses. The deferred worker
+// waits for OpenVPN to confirm every kill. A synchronous hook cannot: OpenVPN
+// only reads the commands once the hook returns, so they are queued and the
+// outcome is not known.
+func killConnections(ctx context.Context, log *logger.Logger, cfg *config.Config, info *clientInfo, addresses []string) error {
+	mgmt := management.NewClient(&cfg.OpenVPN.Management)
+	if info.deferred {
+		return mgmt.Kill(ctx, addresses...)
+	}
+	if err := mgmt.QueueKill(ctx, addresses...); err != nil {
+		return err
+	}
+	log.Warn("kill commands queued, not confirmed until the hook returns", "client_addresses", addresses)
+	return nil
+}
+
+// recordConnection records the connection for session limits
+func recordConnection(log *logger.Logger, store *session.Store, info *clientInfo, now time.Time) {
+	if info.trustedIP == "" {
+		return
+	}
+	conn := &session.Connection{CommonName: info.commonName, TrustedIP: info.trustedIP, TrustedPort: info.trustedPort, ConnectedAt: now}
+	if err := store.AddConnection(conn); err != nil {
+		log.Warn("could not record connection", "error", err)
+	}
+}
+
+// forgetConnection removes the record of a connection refused after
+// admitConnection
+func forgetConnection(log *logger.Logger, store *session.Store, info *clientInfo) {
+	if info.trustedIP == "" {
+		return
+	}
+	unlock, err := store.Lock(info.commonName)
+	if err != nil {
+		log.Warn("could not remove connection record", "error", err)
+		return
+	}
+	defer unlock()
+
+	if err := store.RemoveConnection(info.commonName, info.trustedIP, info.trustedPort); err != nil {
+		log.Warn("could not remove connection record", "error", err)
+	}
+}
+
+// liveConnections returns the recorded connections of the client's common
+// name, removing records left over from before OpenVPN was (re)started. A
+// record of the client's own connection is not counted.
+func liveConnections(store *session.Store, info *clientInfo) ([]session.Connection, error) {
+	conns, err := store.Connections(info.commonName)
+	if err != nil {
+		return nil, err
+	}
+
+	started, _ := strconv.ParseInt(os.Getenv("daemon_start_time"), 10, 64)
+	live := conns[:0]
+	for _, conn := range conns {
+		if conn.TrustedIP == info.trustedIP && conn.TrustedPort == info.trustedPort {
+			continue
+		}
+		if started > 0 && conn.ConnectedAt.Unix() < started {
+			_ = store.RemoveConnection(conn.CommonName, conn.TrustedIP, conn.TrustedPort)
+			continue
+		}
+		live = append(live, conn)
+	}
+	return live, nil
+}
@@ -2,25 +2,29 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
-	"fmt"
 	"log/slog"
 	"os"
-	"path/filepath"
-	"strings"
+	"time"
 
+	"github.com/tldr-it-stepankutaj/openvpn-client/internal/access"
 	"github.com/tldr-it-stepankutaj/openvpn-client/internal/api"
+	"github.com/tldr-it-stepankutaj/openvpn-client/internal/cache"
 	"github.com/tldr-it-stepankutaj/openvpn-client/internal/config"
+	"github.com/tldr-it-stepankutaj/openvpn-client/internal/deferred"
 	"github.com/tldr-it-stepankutaj/openvpn-client/internal/logger"
-	"github.com/tldr-it-stepankutaj/openvpn-client/internal/utils"
+	"github.com/tldr-it-stepankutaj/openvpn-client/internal/session"
 )
 
 const programName = "openvpn-connect"
 
 func main() {
 	var configPath string
+	var worker bool
 	flag.StringVar(&configPath, "config", "", "path to configuration file")
 	flag.StringVar(&configPath, "c", "", "path to configuration file (shorthand)")
+	flag.BoolVar(&worker, "worker", false, "run as deferred client-connect worker (internal)")
 	flag.Parse()
 
 	// Initialize logger
@@ -40,35 +44,77 @@ func main() {
 	openvpnConfigFile := args[0]
 
 	// Get environment variables from OpenVPN
-	commonName := os.Getenv("common_name")
-	if commonName == "" {
+	info := &clientInfo{
+		commonName:  os.Getenv("common_name"),
+		trustedIP:   os.Getenv("trusted_ip"),
+		trustedPort: os.Getenv("trusted_port"),
+		remoteIP:    os.Getenv("ifconfig_pool_remote_ip"),
+	}
+	if info.commonName == "" {
 		log.Error("common_name environment variable not set")
+		if worker {
+			denyAndExit(log)
+		}
 		os.Exit(1)
 	}
 
-	trustedIP := os.Getenv("trusted_ip")
-	trustedPort := os.Getenv("trusted_port")
-	remoteIP := os.Getenv("ifconfig_pool_remote_ip")
-
-	userLog := log.WithUser(commonName)
+	userLog := log.WithUser(info.commonName)
 
 	// Load configuration
 	cfg, err := config.Load(configPath)
 	if err != nil {
 		userLog.Error("failed to load config", "error", err)
+		if worker {
+			denyAndExit(userLog)
+		}
 		os.Exit(1)
 	}
 
+	if worker {
+		info.deferred = true
+		runWorker(userLog, cfg, info)
+	}
+
+	// Hand the API calls to a background worker so OpenVPN is not blocked
+	if cfg.OpenVPN.DeferredConnect.Enabled {
+		if dc := deferred.ConnectFromEnv(); dc != nil {
+			startWorker(userLog, cfg, dc, configPath, openvpnConfigFile, info)
+		} else {
+			userLog.Warn("client_connect_deferred_file not provided, connecting synchronously")
+		}
+	}
+
+	deliver := func(content []byte) error {
+		return os.WriteFile(openvpnConfigFile, content, 0644)
+	}
+	os.Exit(connect(context.Background(), userLog, cfg, info, deliver))
+}
+
+// clientInfo holds the connecting client's OpenVPN environment
+type clientInfo struct {
+	commonName  string
+	trustedIP   string
+	trustedPort string
+	remoteIP    string
+	// deferred is set in the deferred worker, where OpenVPN serves the
+	// management interface while the connect runs
+	deferred bool
+}
+
+// connect checks the user, builds the client config and hands it to deliver.
+// Returns the exit code for OpenVPN.
+func connect(ctx context.Context, userLog *logger.Logger, cfg *config.Config, info *clientInfo, deliver func([]byte) error) int {
+	commonName := info.commonName
+	trustedIP := info.trustedIP
+
 	// Create API client
 	client := api.NewClient(&cfg.API)
 
-	ctx := context.Background()
-
 	// Authenticate if using a legacy service account
 	if !cfg.API.UseToken() {
 		if err := client.Authenticate(ctx, cfg.API.Username, cfg.API.Password); err != nil {
 			userLog.Error("API authentication failed", "error", err)
-			os.Exit(1)
+			return 1
 		}
 	}
 
@@ -76,79 +122,112 @@ func main() {
 	user, err := client.GetUserByUsername(ctx, commonName)
 	if err != nil {
 		userLog.Error("user not found", "error", err)
-		os.Exit(1)
+		return 1
 	}
 
 	userLog = userLog.With("user_id", user.ID)
 
-	// Build config file content
-	var configContent strings.Builder
+	// Refuse users outside their validity window
+	now := time.Now()
+	if err := user.CheckValidity(now, cfg.OpenVPN.Skew()); err != nil {
+		userLog.Warn("connection rejected", "reason", "outside_validity_window", "error", err)
+		return 1
+	}
+
+	// Refuse source addresses that are blocklisted or outside the user's
+	// and groups' allow/deny lists
+	if err := checkSource(ctx, userLog, cfg, client, user); err != nil {
+		if errors.Is(err, access.ErrSourceDenied) {
+			userLog.Warn("connection rejected", "reason", "source_denied", "source_ip", trustedIP, "error", err)
+		} else {
+			userLog.Error("failed to check source address", "source_ip", trustedIP, "error", err)
+		}
+		return 1
+	}
+
+	// Enforce concurrent session limits and record the connection
+	store := session.NewStore(cfg.OpenVPN.SessionDir)
+	if err := admitConnection(ctx, userLog, cfg, client, store, user, info, now); err != nil {
+		userLog.Warn("connection rejected", "reason", "session_limit", "error", err)
+		return 1
+	}
+	refuse := func() int {
+		forgetConnection(userLog, store, info)
+		return 1
+	}
 
-	// Set static VPN IP if configured
-	vpnIP := remoteIP
-	if user.VpnIP != "" {
-		vpnIP = user.VpnIP
-		configContent.WriteString(fmt.Sprintf("ifconfig-push %s 255.255.255.0\n", user.VpnIP))
+	// Cache the user for learn-address and other hooks
+	users := cache.NewUserCache(cfg.OpenVPN.SessionDir, cfg.OpenVPN.UserCacheTTL)
+	if err := users.Put(user); err != nil {
+		userLog.Warn("could not cache user", "error", err)
 	}
 
 	// Get user's routes
 	routes, err := client.GetUserRoutes(ctx, user.ID)
 	if err != nil {
 		userLog.Error("failed to get user routes", "error", err)
-		os.Exit(1)
+		return refuse()
 	}
 
-	// Check for default route and collect networks
-	hasDefaultRoute := false
-	var networks []string
-
-	for _, route := range routes {
-		if utils.IsDefaultRoute(route.CIDR) {
-			hasDefaultRoute = true
-			continue
-		}
-		networks = append(networks, route.CIDR)
-	}
-
-	// Push routes
-	if hasDefaultRoute {
-		configContent.WriteString("push \"redirect-gateway def1\"\n")
-	} else {
-		for _, cidr := range networks {
-			route, err := utils.CIDRToNetmask(cidr)
-			if err != nil {
-				userLog.Warn("invalid CIDR, skipping", "cidr", cidr, "error", err)
-				continue
-			}
-			configContent.WriteString(fmt.Sprintf("push \"route %s\"\n", route))
-		}
+	// Cache the routes for the deferred connect fallback
+	routeCache := cache.NewRouteCache(cfg.OpenVPN.SessionDir, cfg.OpenVPN.UserCacheTTL)
+	if err := routeCache.Put(commonName, routes); err != nil {
+		userLog.Warn("could not cache routes", "error", err)
 	}
 
 	// Write a config file
-	if err := os.WriteFile(openvpnConfigFile, []byte(configContent.String()), 0644); err != nil {
-		userLog.Error("failed to write config file", "path", openvpnConfigFile, "error", err)
-		os.Exit(1)
+	clientCfg := buildConfig(userLog, user, routes, info.remoteIP, now)
+	content := clientCfg.content + pushAuthToken(userLog, cfg, commonName, user, now)
+	if err := deliver([]byte(content)); err != nil {
+		userLog.Error("failed to write config file", "error", err)
+		return refuse()
 	}
 
 	// Create VPN session
-	session, err := client.CreateSession(ctx, user.ID, vpnIP, trustedIP)
+	vpnSession, err := client.CreateSession(ctx, user.ID, clientCfg.vpnIP, trustedIP)
 	if err != nil {
 		userLog.Warn("could not create session", "error", err)
 	} else {
 		// Save session ID for disconnect script
-		sessionFile := filepath.Join(cfg.OpenVPN.SessionDir, fmt.Sprintf("session-%s", commonName))
-		sessionData := fmt.Sprintf("%s\n%s\n%s", session.ID, trustedIP, trustedPort)
-		if err := os.WriteFile(sessionFile, []byte(sessionData), 0600); err != nil {
-			userLog.Warn("could not save session file", "path", sessionFile, "error", err)
+		sess := &session.Session{ID: vpnSession.ID, TrustedIP: trustedIP, TrustedPort: info.trustedPort}
+		if err := store.Save(commonName, sess); err != nil {
+			userLog.Warn("could not save session file", "path", store.SessionPath(commonName, trustedIP, info.trustedPort), "error", err)
 		}
-		userLog = userLog.WithSession(session.ID)
+		userLog = userLog.WithSession(vpnSession.ID)
 	}
 
 	userLog.Info("client connected",
-		"vpn_ip", vpnIP,
+		"vpn_ip", clientCfg.vpnIP,
 		"client_ip", trustedIP,
-		"routes_count", len(networks),
-		"default_route", hasDefaultRoute,
+		"routes_count", len(clientCfg.networks),
+		"routes_outside_schedule", clientCfg.inactive,
+		"default_route", clientCfg.hasDefaultRoute,
 	)
-	os.Exit(0)
+	return 0
+}
+
+// checkSource checks the client's real address against the local blocklist
+// and the source rules of the user and their groups
+func checkSource(ctx context.Context, log *logger.Logger, cfg *config.Config, client *api.Client, user *api.UserResponse) error {
+	source, err := access.SourceFromEnv("trusted_ip")
+	if err != nil {
+		log.Warn("could not determine source address", "error", err)
+	}
+	if !source.IsValid() {
+		return nil
+	}
+
+	invalid, err := access.CheckBlocklist(cfg.OpenVPN.SourceFilter.BlocklistFile, source)
+	if len(invalid) > 0 {
+		log.Warn("ignoring invalid blocklist entries", "entries", invalid)
+	}
+	if err != nil {
+		return err
+	}
+
+	rules, err := access.UserSourceRules(ctx, client, user, cfg.OpenVPN.SourceFilter.GroupRules)
+	if err != nil {
+		return err
+	}
+	return access.CheckSource(source, rules)
 }
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/tldr-it-stepankutaj/openvpn-client/internal/api"
+	"github.com/tldr-it-stepankutaj/openvpn-client/internal/authtoken"
+	"github.com/tldr-it-stepankutaj/openvpn-client/internal/config"
+	"github.com/tldr-it-stepankutaj/openvpn-client/internal/logger"
+)
+
+// pushAuthToken returns the directive handing the client a session token,
+// which it sends instead of the password on renegotiation. Returns "" when
+// tokens are disabled or cannot be issued.
+func pushAuthToken(log *logger.Logger, cfg *config.Config, commonName string, user *api.UserResponse, now time.Time) string {
+	tokenCfg := &cfg.OpenVPN.AuthToken
+	if !tokenCfg.Enabled {
+		return ""
+	}
+
+	signer, err := authtoken.LoadSigner(tokenCfg.SecretFile)
+	if err != nil {
+		log.Warn("could not issue auth token", "error", err)
+		return ""
+	}
+
+	// Tokens never outlive the user's validity window
+	expires := now.Add(tokenCfg.Lifetime)
+	if user.ValidTo != nil && user.ValidTo.Before(expires) {
+		expires = *user.ValidTo
+	}
+
+	token, err := signer.Issue(commonName, expires)
+	if err != nil {
+		log.Warn("could not issue auth token", "error", err)
+		return ""
+	}
+	return fmt.Sprintf("push \"auth-token %s\"\n", token)
+}
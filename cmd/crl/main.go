@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log/slog"
+	"os"
+
+	"github.com/tldr-it-stepankutaj/openvpn-client/internal/api"
+	"github.com/tldr-it-stepankutaj/openvpn-client/internal/config"
+	"github.com/tldr-it-stepankutaj/openvpn-client/internal/logger"
+	"github.com/tldr-it-stepankutaj/openvpn-client/internal/utils"
+)
+
+const programName = "openvpn-crl"
+
+// Exit codes
+const (
+	exitOK      = 0
+	exitError   = 1
+	exitPartial = 4 // some users could not be looked up, their certificates were kept
+)
+
+func main() {
+	var (
+		configPath string
+		dryRun     bool
+		diffFormat string
+	)
+	flag.StringVar(&configPath, "config", "", "path to configuration file")
+	flag.StringVar(&configPath, "c", "", "path to configuration file (shorthand)")
+	flag.BoolVar(&dryRun, "dry-run", false, "print certificates to revoke without changing the index or CRL")
+	flag.BoolVar(&dryRun, "n", false, "print certificates to revoke without changing the index or CRL (shorthand)")
+	flag.StringVar(&diffFormat, "diff-format", "text", "diff output format: text or json")
+	flag.Parse()
+
+	// Initialize logger
+	log := logger.New(logger.Options{
+		Level:   slog.LevelInfo,
+		JSON:    true,
+		Program: programName,
+	})
+
+	if !utils.ValidDiffFormat(diffFormat) {
+		log.Error("invalid diff format", "format", diffFormat)
+		os.Exit(exitError)
+	}
+
+	// Load configuration
+	cfg, err := config.Load(configPath)
+	if err != nil {
+		log.Error("failed to load config", "error", err)
+		os.Exit(exitError)
+	}
+
+	r := &revoker{
+		cfg:        cfg,
+		log:        log,
+		client:     api.NewClient(&cfg.API),
+		dryRun:     dryRun,
+		diffFormat: diffFormat,
+	}
+	os.Exit(r.run(context.Background()))
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/tldr-it-stepankutaj/openvpn-client/internal/api"
+	"github.com/tldr-it-stepankutaj/openvpn-client/internal/config"
+	"github.com/tldr-it-stepankutaj/openvpn-client/internal/logger"
+	"github.com/tldr-it-stepankutaj/openvpn-client/internal/pki"
+	"github.com/tldr-it-stepankutaj/openvpn-client/internal/utils"
+)
+
+// Reasons a certificate is revoked
+const (
+	reasonInactive    = "inactive"
+	reasonExpired     = "expired"
+	reasonUnknownUser = "unknown_user"
+)
+
+// revoker revokes certificates of users the API no longer allows and
+// regenerates the CRL
+type revoker struct {
+	cfg    *config.Config
+	log    *logger.Logger
+	client *api.Client
+
+	dryRun     bool
+	diffFormat string
+}
+
+// revocation is a certificate revoked by this run
+type revocation struct {
+	Username string `json:"username"`
+	Serial   string `json:"serial"`
+	Reason   string `json:"reason"`
+}
+
+// diff lists the certificates revoked by this run
+type diff struct {
+	Revoked []revocation `json:"revoked"`
+}
+
+// run revokes certificates, writes the index and CRL and returns the
+// process exit code
+func (r *revoker) run(ctx context.Context) int {
+	log := r.log
+	pkiCfg := &r.cfg.PKI
+
+	// Serialise index updates with openvpn-pki and openvpn-profile
+	if !r.dryRun {
+		unlock, err := pki.LockIndex(pkiCfg.IndexFile)
+		if err != nil {
+			log.Error("failed to lock certificate index", "file", pkiCfg.IndexFile, "error", err)
+			return exitError
+		}
+		defer unlock()
+	}
+
+	idx, err := pki.LoadIndex(pkiCfg.IndexFile)
+	if err != nil {
+		log.Error("failed to load certificate index", "file", pkiCfg.IndexFile, "error", err)
+		return exitError
+	}
+
+	// Authenticate if using a legacy service account
+	if !r.cfg.API.UseToken() {
+		if err := r.client.Authenticate(ctx, r.cfg.API.Username, r.cfg.API.Password); err != nil {
+			log.Error("API authentication failed", "error", err)
+			return exitError
+		}
+	}
+
+	now := time.Now()
+	d, failed := r.revoke(ctx, idx, now)
+
+	code := exitOK
+	if failed > 0 {
+		log.Warn("some users could not be looked up, keeping their certificates", "failed_users", failed)
+		code = exitPartial
+	}
+
+	// Dry run - print the diff
+	if r.dryRun {
+		log.Info("dry run mode - printing diff", "revoked", len(d.Revoked))
+		if err := utils.PrintDiff(os.Stdout, d, r.diffFormat); err != nil {
+			log.Error("failed to print diff", "error", err)
+			return exitError
+		}
+		return code
+	}
+
+	prev, err := pki.LoadCRL(pkiCfg.CRLFile)
+	if err != nil {
+		log.Warn("ignoring unreadable CRL", "file", pkiCfg.CRLFile, "error", err)
+	}
+	if len(d.Revoked) == 0 && prev != nil && !pki.CRLStale(prev, now) {
+		log.Info("CRL unchanged", "file", pkiCfg.CRLFile, "next_update", prev.NextUpdate)
+		return code
+	}
+
+	ca, err := pki.LoadCA(pkiCfg.CACert, pkiCfg.CAKey)
+	if err != nil {
+		log.Error("failed to load CA", "error", err)
+		return exitError
+	}
+	crl, err := ca.CreateCRL(idx, prev, now, pkiCfg.CRLValidity)
+	if err != nil {
+		log.Error("failed to create CRL", "error", err)
+		return exitError
+	}
+
+	// Write the CRL first: if the index write fails, the next run revokes
+	// the same certificates again
+	if err := pki.SaveCRL(pkiCfg.CRLFile, crl); err != nil {
+		log.Error("failed to write CRL", "file", pkiCfg.CRLFile, "error", err)
+		return exitError
+	}
+	if len(d.Revoked) > 0 {
+		if err := idx.Save(pkiCfg.IndexFile); err != nil {
+			log.Error("failed to write certificate index", "file", pkiCfg.IndexFile, "error", err)
+			return exitError
+		}
+	}
+
+	log.Info("CRL updated",
+		"file", pkiCfg.CRLFile,
+		"revoked", len(d.Revoked),
+		"next_update", now.Add(pkiCfg.CRLValidity).UTC(),
+	)
+
+	// Machine-readable diff for change-review pipelines
+	if r.diffFormat == "json" {
+		if err := utils.PrintDiff(os.Stdout, d, r.diffFormat); err != nil {
+			log.Warn("failed to print diff", "error", err)
+		}
+	}
+	return code
+}
+
+// revoke marks valid certificates of inactive, expired and (optionally)
+// unknown users revoked. Users that cannot be looked up keep their
+// certificates; their number is returned.
+func (r *revoker) revoke(ctx context.Context, idx *pki.Index, now time.Time) (*diff, int) {
+	d := &diff{Revoked: []revocation{}}
+	reasons := map[string]string{} // username -> revocation reason, "" = keep
+	failed := 0
+
+	for i := range idx.Entries {
+		entry := &idx.Entries[i]
+		// Expired certificates are refused by OpenVPN anyway
+		if entry.Status != pki.StatusValid || !entry.Expires.After(now) {
+			continue
+		}
+
+		username := entry.CommonName()
+		if username == "" || slices.Contains(r.cfg.PKI.ExcludeCNs, username) {
+			continue
+		}
+
+		reason, ok := reasons[username]
+		if !ok {
+			var err error
+			reason, err = r.userReason(ctx, username, now)
+			if err != nil {
+				r.log.WithUser(username).Warn("failed to look up user", "error", err)
+				failed++
+			}
+			reasons[username] = reason
+		}
+		if reason == "" {
+			continue
+		}
+
+		entry.Revoke(now, pki.ReasonCessationOfOperation)
+		d.Revoked = append(d.Revoked, revocation{Username: username, Serial: entry.Serial, Reason: reason})
+		r.log.WithUser(username).Info("revoking certificate", "serial", entry.Serial, "reason", reason)
+	}
+	return d, failed
+}
+
+// userReason returns why the user's certificates must be revoked, or ""
+// to keep them
+func (r *revoker) userReason(ctx context.Context, username string, now time.Time) (string, error) {
+	user, err := r.client.GetUserByUsername(ctx, username)
+	if errors.Is(err, api.ErrUserNotFound) {
+		if r.cfg.PKI.RevokeUnknown {
+			return reasonUnknownUser, nil
+		}
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	if !user.IsActive {
+		return reasonInactive, nil
+	}
+	if err := user.CheckValidity(now, r.cfg.OpenVPN.Skew()); errors.Is(err, api.ErrExpired) {
+		return reasonExpired, nil
+	}
+	return "", nil
+}
+
+// String returns a human-readable representation of the diff
+func (d *diff) String() string {
+	if len(d.Revoked) == 0 {
+		return "no changes\n"
+	}
+
+	var out strings.Builder
+	for _, rev := range d.Revoked {
+		out.WriteString(fmt.Sprintf("- %s serial %s (%s)\n", rev.Username, rev.Serial, rev.Reason))
+	}
+	out.WriteString(fmt.Sprintf("%d certificates revoked\n", len(d.Revoked)))
+	return out.String()
+}
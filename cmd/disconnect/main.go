@@ -2,17 +2,16 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
-	"fmt"
 	"log/slog"
 	"os"
-	"path/filepath"
 	"strconv"
-	"strings"
 
 	"github.com/tldr-it-stepankutaj/openvpn-client/internal/api"
 	"github.com/tldr-it-stepankutaj/openvpn-client/internal/config"
 	"github.com/tldr-it-stepankutaj/openvpn-client/internal/logger"
+	"github.com/tldr-it-stepankutaj/openvpn-client/internal/session"
 )
 
 const programName = "openvpn-disconnect"
@@ -50,20 +49,27 @@ func main() {
 	}
 
 	// Read the session file
-	sessionFile := filepath.Join(cfg.OpenVPN.SessionDir, fmt.Sprintf("session-%s", commonName))
-	data, err := os.ReadFile(sessionFile)
-	if err != nil {
-		userLog.Warn("session file not found, nothing to disconnect", "path", sessionFile)
-		os.Exit(0)
+	store := session.NewStore(cfg.OpenVPN.SessionDir)
+
+	// Forget the connection for session limits
+	trustedIP, trustedPort := os.Getenv("trusted_ip"), os.Getenv("trusted_port")
+	if trustedIP != "" {
+		if err := store.RemoveConnection(commonName, trustedIP, trustedPort); err != nil {
+			userLog.Warn("could not remove connection record", "error", err)
+		}
 	}
 
-	lines := strings.Split(string(data), "\n")
-	if len(lines) < 1 {
-		userLog.Warn("invalid session file format")
+	sess, err := store.Load(commonName, trustedIP, trustedPort)
+	if err != nil {
+		if errors.Is(err, session.ErrNotFound) {
+			userLog.Warn("session file not found, nothing to disconnect", "path", store.SessionPath(commonName, trustedIP, trustedPort))
+		} else {
+			userLog.Warn("invalid session file format", "error", err)
+		}
 		os.Exit(0)
 	}
 
-	sessionID := strings.TrimSpace(lines[0])
+	sessionID := sess.ID
 	userLog = userLog.WithSession(sessionID)
 
 	// Create API client
@@ -85,8 +91,8 @@ func main() {
 	}
 
 	// Remove session file
-	if err := os.Remove(sessionFile); err != nil {
-		userLog.Warn("could not remove session file", "path", sessionFile, "error", err)
+	if err := store.Remove(commonName, trustedIP, trustedPort); err != nil {
+		userLog.Warn("could not remove session file", "path", store.SessionPath(commonName, trustedIP, trustedPort), "error", err)
 	}
 
 	userLog.Info("client disconnected",
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"math/rand/v2"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// retryDelay is the first delay after a failed sync; it doubles up to
+// firewall.daemon.max_backoff on consecutive failures
+const retryDelay = 15 * time.Second
+
+// runDaemon syncs rules periodically until SIGTERM/SIGINT.
+// SIGHUP forces an immediate sync.
+func runDaemon(s *syncer) int {
+	daemonCfg := s.cfg.Firewall.Daemon
+	log := s.log.With("mode", "daemon")
+
+	// Reuse cached API responses when the server reports no change
+	s.client.EnableConditionalRequests()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP, syscall.SIGTERM, syscall.SIGINT)
+	defer signal.Stop(signals)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	log.Info("firewall daemon started",
+		"interval", daemonCfg.Interval.String(),
+		"jitter", daemonCfg.Jitter.String(),
+	)
+
+	failures := 0
+	for {
+		done := make(chan int, 1)
+		go func() {
+			done <- s.sync(ctx)
+		}()
+
+		// Wait for the sync to finish; a termination signal cancels it. A
+		// SIGHUP cannot interrupt the sync, so another one follows right away.
+		var code int
+		pending := false
+	waitSync:
+		for {
+			select {
+			case code = <-done:
+				break waitSync
+			case sig := <-signals:
+				if sig == syscall.SIGHUP {
+					log.Info("SIGHUP received during sync, syncing again when done")
+					pending = true
+					continue
+				}
+				log.Info("shutting down", "signal", sig.String())
+				cancel()
+				<-done
+				return exitOK
+			}
+		}
+
+		var delay time.Duration
+		if code == exitOK {
+			failures = 0
+			delay = daemonCfg.Interval + jitter(daemonCfg.Jitter)
+		} else {
+			failures++
+			delay = backoff(failures, daemonCfg.MaxBackoff)
+			log.Warn("sync failed, backing off",
+				"exit_code", code,
+				"failures", failures,
+				"retry_in", delay.String(),
+			)
+		}
+		if pending {
+			log.Info("forcing sync requested during the previous one")
+			continue
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case sig := <-signals:
+			timer.Stop()
+			if sig != syscall.SIGHUP {
+				log.Info("shutting down", "signal", sig.String())
+				return exitOK
+			}
+			log.Info("SIGHUP received, forcing sync")
+		}
+	}
+}
+
+// jitter returns a random duration in [0, limit)
+func jitter(limit time.Duration) time.Duration {
+	if limit <= 0 {
+		return 0
+	}
+	return rand.N(limit)
+}
+
+// backoff returns the retry delay after the given number of consecutive failures
+func backoff(failures int, limit time.Duration) time.Duration {
+	delay := retryDelay
+	for i := 1; i < failures && delay < limit; i++ {
+		delay *= 2
+	}
+	if delay > limit {
+		delay = limit
+	}
+	return delay
+}
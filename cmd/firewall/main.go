@@ -5,25 +5,46 @@ import (
 	"flag"
 	"log/slog"
 	"os"
-	"os/exec"
+	"time"
 
 	"github.com/tldr-it-stepankutaj/openvpn-client/internal/api"
 	"github.com/tldr-it-stepankutaj/openvpn-client/internal/config"
 	"github.com/tldr-it-stepankutaj/openvpn-client/internal/firewall"
 	"github.com/tldr-it-stepankutaj/openvpn-client/internal/logger"
+	"github.com/tldr-it-stepankutaj/openvpn-client/internal/utils"
 )
 
 const programName = "openvpn-firewall"
 
+// Exit codes
+const (
+	exitOK          = 0
+	exitError       = 1
+	exitSafetyGuard = 3 // mass-change thresholds exceeded
+	exitPartial     = 4 // routes could not be fetched for some users
+)
+
 func main() {
 	var (
-		configPath string
-		dryRun     bool
+		configPath   string
+		dryRun       bool
+		force        bool
+		allowPartial bool
+		showRules    bool
+		diffFormat   string
+		daemon       bool
+		interval     time.Duration
 	)
 	flag.StringVar(&configPath, "config", "", "path to configuration file")
 	flag.StringVar(&configPath, "c", "", "path to configuration file (shorthand)")
 	flag.BoolVar(&dryRun, "dry-run", false, "print rules without applying")
 	flag.BoolVar(&dryRun, "n", false, "print rules without applying (shorthand)")
+	flag.BoolVar(&force, "force", false, "apply even if mass-change thresholds are exceeded")
+	flag.BoolVar(&allowPartial, "allow-partial", false, "apply even if routes could not be fetched for some users")
+	flag.BoolVar(&showRules, "show-rules", false, "print the full generated rules in dry run mode")
+	flag.StringVar(&diffFormat, "diff-format", "text", "diff output format: text or json")
+	flag.BoolVar(&daemon, "daemon", false, "run continuously, syncing rules periodically")
+	flag.DurationVar(&interval, "interval", 0, "sync interval in daemon mode (overrides firewall.daemon.interval)")
 	flag.Parse()
 
 	// Initialize logger
@@ -33,93 +54,42 @@ func main() {
 		Program: programName,
 	})
 
-	// Load configuration
-	cfg, err := config.Load(configPath)
-	if err != nil {
-		log.Error("failed to load config", "error", err)
-		os.Exit(1)
-	}
-
-	// Create API client
-	client := api.NewClient(&cfg.API)
-
-	ctx := context.Background()
-
-	// Authenticate if using a legacy service account
-	if !cfg.API.UseToken() {
-		if err := client.Authenticate(ctx, cfg.API.Username, cfg.API.Password); err != nil {
-			log.Error("API authentication failed", "error", err)
-			os.Exit(1)
-		}
+	if !utils.ValidDiffFormat(diffFormat) {
+		log.Error("invalid diff format", "format", diffFormat)
+		os.Exit(exitError)
 	}
 
-	// Get all active users
-	users, err := client.GetAllActiveUsers(ctx)
-	if err != nil {
-		log.Error("failed to get users", "error", err)
-		os.Exit(1)
+	if daemon && dryRun {
+		log.Error("--daemon cannot be combined with --dry-run")
+		os.Exit(exitError)
 	}
 
-	log.Info("fetched active users", "count", len(users))
-
-	// Collect networks for each user
-	usersWithNetworks, err := firewall.CollectUserNetworks(ctx, client, users)
+	// Load configuration
+	cfg, err := config.Load(configPath)
 	if err != nil {
-		log.Error("failed to collect networks", "error", err)
-		os.Exit(1)
-	}
-
-	log.Info("collected user networks", "users_with_rules", len(usersWithNetworks))
-
-	// Create a firewall generator
-	fw := firewall.New(&cfg.Firewall)
-
-	// Generate rules
-	newRules := fw.GenerateRules(usersWithNetworks)
-
-	// Dry run - just print rules
-	if dryRun {
-		log.Info("dry run mode - printing rules")
-		_, err := os.Stdout.WriteString(newRules)
-		if err != nil {
-			return
-		}
-		os.Exit(0)
+		log.Error("failed to load config", "error", err)
+		os.Exit(exitError)
 	}
 
-	// Check if rules changed
-	rulesFile := fw.GetRulesFile()
-	oldRules, _ := os.ReadFile(rulesFile)
-	if string(oldRules) == newRules {
-		log.Info("firewall rules unchanged", "file", rulesFile)
-		os.Exit(0)
+	if interval > 0 {
+		cfg.Firewall.Daemon.Interval = interval
 	}
 
-	// Write new rules
-	if err := os.WriteFile(rulesFile, []byte(newRules), 0644); err != nil {
-		log.Error("failed to write rules file", "file", rulesFile, "error", err)
-		os.Exit(1)
+	s := &syncer{
+		cfg:          cfg,
+		log:          log,
+		client:       api.NewClient(&cfg.API),
+		fw:           firewall.New(&cfg.Firewall),
+		dryRun:       dryRun,
+		force:        force,
+		allowPartial: allowPartial,
+		showRules:    showRules,
+		diffFormat:   diffFormat,
 	}
 
-	log.Info("wrote firewall rules", "file", rulesFile)
-
-	// Reload firewall
-	reloadCmd := fw.GetReloadCommand()
-	cmd := exec.Command("sh", "-c", reloadCmd)
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		log.Error("failed to reload firewall",
-			"command", reloadCmd,
-			"output", string(output),
-			"error", err,
-		)
-		os.Exit(1)
+	if daemon {
+		os.Exit(runDaemon(s))
 	}
 
-	log.Info("firewall rules updated",
-		"users", len(usersWithNetworks),
-		"type", cfg.Firewall.Type,
-		"file", rulesFile,
-	)
-	os.Exit(0)
+	os.Exit(s.sync(context.Background()))
 }
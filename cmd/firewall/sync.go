@@ -0,0 +1,334 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"time"
+
+	"github.com/tldr-it-stepankutaj/openvpn-client/internal/api"
+	"github.com/tldr-it-stepankutaj/openvpn-client/internal/config"
+	"github.com/tldr-it-stepankutaj/openvpn-client/internal/firewall"
+	"github.com/tldr-it-stepankutaj/openvpn-client/internal/logger"
+	"github.com/tldr-it-stepankutaj/openvpn-client/internal/utils"
+)
+
+// syncer fetches users from the API and applies firewall rules
+type syncer struct {
+	cfg    *config.Config
+	log    *logger.Logger
+	client *api.Client
+	fw     firewall.Firewall
+
+	dryRun       bool
+	force        bool
+	allowPartial bool
+	showRules    bool
+	diffFormat   string
+}
+
+// sync runs a single synchronisation and returns the process exit code
+func (s *syncer) sync(ctx context.Context) int {
+	log := s.log
+
+	// Authenticate if using a legacy service account
+	if !s.cfg.API.UseToken() {
+		if err := s.client.Authenticate(ctx, s.cfg.API.Username, s.cfg.API.Password); err != nil {
+			log.Error("API authentication failed", "error", err)
+			return exitError
+		}
+	}
+
+	// Parse current rules to guard against mass removals
+	rulesFile := s.fw.GetRulesFile()
+	oldRules, _ := os.ReadFile(rulesFile)
+	currentUsers := s.fw.ParseRules(string(oldRules))
+
+	usersWithNetworks, newRules, code := s.collect(ctx, currentUsers)
+	if code != exitOK {
+		return code
+	}
+
+	log.Info("collected user networks", "users_with_rules", len(usersWithNetworks))
+
+	// Compare with the current rules at the user/network level
+	diff := firewall.ComputeDiff(currentUsers, usersWithNetworks)
+
+	// Guard against mass removals (e.g. API returning an empty user list)
+	if err := firewall.CheckSafety(currentUsers, diff, &s.cfg.Firewall.Safety); err != nil {
+		switch {
+		case s.dryRun:
+			log.Warn("mass-change guard would block apply", "error", err)
+		case s.force:
+			log.Warn("mass-change guard overridden by --force", "error", err)
+		default:
+			log.Error("refusing to apply firewall rules", append([]any{"error", err}, diff.LogAttrs()...)...)
+			return exitSafetyGuard
+		}
+	}
+
+	// Dry run - print the diff (and optionally the rules)
+	if s.dryRun {
+		log.Info("dry run mode - printing diff", diff.LogAttrs()...)
+		if err := utils.PrintDiff(os.Stdout, diff, s.diffFormat); err != nil {
+			log.Error("failed to print diff", "error", err)
+			return exitError
+		}
+		if s.showRules {
+			if _, err := os.Stdout.WriteString(newRules); err != nil {
+				return exitError
+			}
+		}
+		return exitOK
+	}
+
+	// Check if rules changed
+	if string(oldRules) == newRules {
+		log.Info("firewall rules unchanged", "file", rulesFile)
+		return exitOK
+	}
+
+	// Validate, install and reload new rules (rolls back on reload failure)
+	if err := firewall.Apply(ctx, s.fw, newRules); err != nil {
+		var reloadErr *firewall.ReloadError
+		if errors.As(err, &reloadErr) {
+			log.Error("failed to reload firewall",
+				"command", reloadErr.Command,
+				"output", reloadErr.Output,
+				"error", reloadErr.Err,
+				"rolled_back", reloadErr.RolledBack,
+				"rollback_error", errString(reloadErr.RollbackErr),
+			)
+		} else {
+			log.Error("failed to apply firewall rules", "file", rulesFile, "error", err)
+		}
+		return exitError
+	}
+
+	log.Info("firewall rules updated", append([]any{
+		"users", len(usersWithNetworks),
+		"type", s.cfg.Firewall.Type,
+		"file", rulesFile,
+	}, diff.LogAttrs()...)...)
+
+	// Machine-readable diff for change-review pipelines
+	if s.diffFormat == "json" {
+		if err := utils.PrintDiff(os.Stdout, diff, s.diffFormat); err != nil {
+			log.Warn("failed to print diff", "error", err)
+		}
+	}
+	return exitOK
+}
+
+// supportsBulk reports whether the API advertises the bulk users-with-routes endpoint
+func (s *syncer) supportsBulk(ctx context.Context) bool {
+	caps, err := s.client.GetCapabilities(ctx)
+	if err != nil {
+		s.log.Warn("could not get API capabilities, using per-user routes", "error", err)
+		return false
+	}
+	return caps.Has(api.FeatureUsersIncludeRoutes)
+}
+
+// collect fetches users and their networks and generates the new rules.
+// Returns the per-user networks granted by the rules for diffing.
+func (s *syncer) collect(ctx context.Context, currentUsers []firewall.UserWithNetworks) ([]firewall.UserWithNetworks, string, int) {
+	if s.cfg.Firewall.Mode == "group" {
+		groups, code := s.collectGroups(ctx, currentUsers)
+		if code != exitOK {
+			return nil, "", code
+		}
+		groups, rejections := firewall.SanitizeGroups(groups)
+		s.reportRejections(rejections)
+		s.log.Info("collected groups", "groups", len(groups))
+		return firewall.ExpandGroups(groups), s.fw.GenerateGroupRules(groups), exitOK
+	}
+
+	var users []firewall.UserWithNetworks
+	if s.supportsBulk(ctx) {
+		// Users and routes in a single paginated stream
+		bulkUsers, total, err := firewall.CollectUserNetworksBulk(ctx, s.client)
+		if err != nil {
+			s.log.Error("failed to get users with routes", "error", err)
+			return nil, "", exitError
+		}
+		s.log.Info("fetched active users", "count", total, "bulk", true)
+		users = bulkUsers
+	} else {
+		perUser, code := s.collectPerUser(ctx, currentUsers)
+		if code != exitOK {
+			return nil, "", code
+		}
+		users = perUser
+	}
+
+	users = s.withinValidity(users)
+	users, rejections := firewall.SanitizeUsers(users)
+	s.reportRejections(rejections)
+	return users, s.fw.GenerateRules(users), exitOK
+}
+
+// withinValidity drops users outside their valid_from/valid_to window.
+// With nftables time bounds, users that are not valid yet are kept and
+// their rules are limited with "meta time" instead.
+func (s *syncer) withinValidity(users []firewall.UserWithNetworks) []firewall.UserWithNetworks {
+	timeBounds := s.cfg.Firewall.Type == "nftables" && s.cfg.Firewall.NFTables.TimeBounds
+	now := time.Now()
+
+	result := users[:0]
+	var excluded []string
+	for _, user := range users {
+		err := user.CheckValidity(now, s.cfg.OpenVPN.Skew())
+		if err == nil || (timeBounds && errors.Is(err, api.ErrNotYetValid)) {
+			result = append(result, user)
+			continue
+		}
+		excluded = append(excluded, user.Username)
+	}
+
+	if len(excluded) > 0 {
+		s.log.Info("excluded users outside their validity window", "users", excluded)
+	}
+	return result
+}
+
+// reportRejections logs API values that were not emitted into the rules
+func (s *syncer) reportRejections(rejections []firewall.Rejection) {
+	for _, r := range rejections {
+		s.log.Warn("rejected invalid firewall entry",
+			"username", r.Username,
+			"group", r.Group,
+			"value", r.Value,
+			"reason", r.Reason,
+		)
+	}
+	if len(rejections) > 0 {
+		s.log.Warn("invalid entries excluded from firewall rules", "count", len(rejections))
+	}
+}
+
+// collectPerUser fetches the user list and then each user's routes
+func (s *syncer) collectPerUser(ctx context.Context, currentUsers []firewall.UserWithNetworks) ([]firewall.UserWithNetworks, int) {
+	users, code := s.activeUsers(ctx)
+	if code != exitOK {
+		return nil, code
+	}
+
+	// Collect networks for each user
+	usersWithNetworks, err := firewall.CollectUserNetworks(ctx, s.client, users, s.collectOptions())
+	if err != nil {
+		failed, code := s.checkPartial(err)
+		if code != exitOK {
+			return nil, code
+		}
+		// Keep existing rules for users we could not refresh
+		usersWithNetworks = keepUsers(usersWithNetworks, currentUsers, failed)
+	}
+
+	return usersWithNetworks, exitOK
+}
+
+// collectGroups fetches the user list and then each user's groups
+func (s *syncer) collectGroups(ctx context.Context, currentUsers []firewall.UserWithNetworks) ([]firewall.GroupWithMembers, int) {
+	users, code := s.activeUsers(ctx)
+	if code != exitOK {
+		return nil, code
+	}
+
+	// Group rules are shared, so users outside their validity window are
+	// always excluded
+	now := time.Now()
+	valid := users[:0]
+	var excluded []string
+	for _, user := range users {
+		if err := user.CheckValidity(now, s.cfg.OpenVPN.Skew()); err != nil {
+			excluded = append(excluded, user.Username)
+			continue
+		}
+		valid = append(valid, user)
+	}
+	if len(excluded) > 0 {
+		s.log.Info("excluded users outside their validity window", "users", excluded)
+	}
+
+	groups, err := firewall.CollectGroupNetworks(ctx, s.client, valid, s.collectOptions())
+	if err != nil {
+		failed, code := s.checkPartial(err)
+		if code != exitOK {
+			return nil, code
+		}
+		// Keep existing rules for users we could not refresh
+		groups = append(groups, firewall.GroupsFromUsers(keepUsers(nil, currentUsers, failed))...)
+	}
+
+	return groups, exitOK
+}
+
+// activeUsers fetches all active users
+func (s *syncer) activeUsers(ctx context.Context) ([]api.UserResponse, int) {
+	users, err := s.client.GetAllActiveUsers(ctx)
+	if err != nil {
+		s.log.Error("failed to get users", "error", err)
+		return nil, exitError
+	}
+
+	s.log.Info("fetched active users", "count", len(users))
+	return users, exitOK
+}
+
+func (s *syncer) collectOptions() firewall.CollectOptions {
+	return firewall.CollectOptions{
+		Concurrency:    s.cfg.Firewall.Concurrency,
+		RequestTimeout: s.cfg.Firewall.RequestTimeout,
+	}
+}
+
+// checkPartial decides whether a collection error blocks the apply.
+// Returns the users that failed, or a non-zero exit code.
+func (s *syncer) checkPartial(err error) ([]string, int) {
+	var collectErr *firewall.CollectError
+	if !errors.As(err, &collectErr) {
+		s.log.Error("failed to collect networks", "error", err)
+		return nil, exitError
+	}
+
+	failed := collectErr.Usernames()
+	if !s.allowPartial && !s.cfg.Firewall.Safety.AllowPartial {
+		s.log.Error("route collection incomplete, refusing to apply",
+			"failed_users", failed,
+			"error", err,
+		)
+		return nil, exitPartial
+	}
+
+	s.log.Warn("route collection incomplete, keeping previous rules for failed users",
+		"failed_users", failed,
+		"error", err,
+	)
+	return failed, exitOK
+}
+
+// keepUsers appends the current rules of the given users to result. Both
+// sides pass the same username validation as the generated rules, so API
+// names that could never appear in the rules file are not matched.
+func keepUsers(result, current []firewall.UserWithNetworks, usernames []string) []firewall.UserWithNetworks {
+	keep := make(map[string]bool, len(usernames))
+	for _, username := range usernames {
+		if firewall.ValidateUsername(username) == nil {
+			keep[username] = true
+		}
+	}
+	for _, user := range current {
+		if keep[user.Username] && firewall.ValidateUsername(user.Username) == nil {
+			result = append(result, user)
+		}
+	}
+	return result
+}
+
+func errString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
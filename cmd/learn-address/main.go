@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"log/slog"
+	"os"
+
+	"github.com/tldr-it-stepankutaj/openvpn-client/internal/api"
+	"github.com/tldr-it-stepankutaj/openvpn-client/internal/cache"
+	"github.com/tldr-it-stepankutaj/openvpn-client/internal/config"
+	"github.com/tldr-it-stepankutaj/openvpn-client/internal/firewall"
+	"github.com/tldr-it-stepankutaj/openvpn-client/internal/logger"
+	"github.com/tldr-it-stepankutaj/openvpn-client/internal/session"
+)
+
+const programName = "openvpn-learn-address"
+
+const (
+	opAdd    = "add"
+	opUpdate = "update"
+	opDelete = "delete"
+)
+
+func main() {
+	var configPath string
+	flag.StringVar(&configPath, "config", "", "path to configuration file")
+	flag.StringVar(&configPath, "c", "", "path to configuration file (shorthand)")
+	flag.Parse()
+
+	// Initialize logger
+	log := logger.New(logger.Options{
+		Level:   slog.LevelInfo,
+		JSON:    true,
+		Program: programName,
+	})
+
+	// OpenVPN calls: <operation> <address> [common_name]
+	args := flag.Args()
+	if len(args) < 2 {
+		log.Error("operation and address not provided")
+		os.Exit(1)
+	}
+
+	operation := args[0]
+	address := args[1]
+	commonName := ""
+	if len(args) > 2 {
+		commonName = args[2]
+	}
+
+	addrLog := log.With("operation", operation, "address", address)
+
+	// Load configuration
+	cfg, err := config.Load(configPath)
+	if err != nil {
+		addrLog.Error("failed to load config", "error", err)
+		os.Exit(1)
+	}
+
+	store := session.NewStore(cfg.OpenVPN.SessionDir)
+	set := firewall.NewAddressSet(&cfg.Firewall)
+	setAddr, isIP := firewall.ParseSetAddress(address)
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.API.Timeout)
+	defer cancel()
+
+	switch operation {
+	case opAdd, opUpdate:
+		if commonName == "" {
+			addrLog.Error("common name not provided")
+			os.Exit(1)
+		}
+		userLog := addrLog.WithUser(commonName)
+
+		user, err := resolveUser(ctx, cfg, commonName)
+		if err != nil {
+			userLog.Error("user not found", "error", err)
+			os.Exit(1)
+		}
+		userLog = userLog.With("user_id", user.ID)
+
+		if !user.IsActive {
+			userLog.Warn("user is inactive, refusing address")
+			os.Exit(1)
+		}
+
+		// Address moved to another client - the record is overwritten below
+		if previous, err := store.LookupAddress(address); err == nil && previous != commonName {
+			userLog.Info("address reassigned", "previous_common_name", previous)
+		}
+
+		if err := store.SaveAddress(address, commonName); err != nil {
+			userLog.Error("failed to record address", "error", err)
+			os.Exit(1)
+		}
+
+		if set != nil && isIP {
+			if err := set.Add(ctx, setAddr); err != nil {
+				userLog.Error("failed to add address to firewall set", "error", err)
+				os.Exit(1)
+			}
+		}
+
+		userLog.Info("address learned", "firewall_set", set != nil && isIP)
+
+	case opDelete:
+		owner, err := store.LookupAddress(address)
+		if err != nil && !errors.Is(err, session.ErrNotFound) {
+			addrLog.Warn("could not read address record", "error", err)
+		}
+		if owner != "" {
+			addrLog = addrLog.WithUser(owner)
+		}
+
+		if set != nil && isIP {
+			if err := set.Remove(ctx, setAddr); err != nil {
+				addrLog.Error("failed to remove address from firewall set", "error", err)
+				os.Exit(1)
+			}
+		}
+
+		if err := store.RemoveAddress(address); err != nil {
+			addrLog.Warn("could not remove address record", "error", err)
+		}
+
+		addrLog.Info("address removed", "firewall_set", set != nil && isIP)
+
+	default:
+		addrLog.Error("unknown operation")
+		os.Exit(1)
+	}
+
+	os.Exit(0)
+}
+
+// resolveUser looks the user up in the local cache first and falls back to the API
+func resolveUser(ctx context.Context, cfg *config.Config, commonName string) (*api.UserResponse, error) {
+	users := cache.NewUserCache(cfg.OpenVPN.SessionDir, cfg.OpenVPN.UserCacheTTL)
+	if user, ok := users.Get(commonName); ok {
+		return user, nil
+	}
+
+	client := api.NewClient(&cfg.API)
+
+	// Authenticate if using a legacy service account
+	if !cfg.API.UseToken() {
+		if err := client.Authenticate(ctx, cfg.API.Username, cfg.API.Password); err != nil {
+			return nil, err
+		}
+	}
+
+	user, err := client.GetUserByUsername(ctx, commonName)
+	if err != nil {
+		return nil, err
+	}
+
+	// Cache errors are not fatal - the next event will hit the API again
+	_ = users.Put(user)
+	return user, nil
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"time"
+
+	"github.com/tldr-it-stepankutaj/openvpn-client/internal/config"
+	"github.com/tldr-it-stepankutaj/openvpn-client/internal/deferred"
+	"github.com/tldr-it-stepankutaj/openvpn-client/internal/logger"
+)
+
+// startWorker hands the credentials to a detached worker and announces a
+// pending authentication when configured
+func startWorker(log *logger.Logger, cfg *config.Config, auth *deferred.Auth, configPath string, credentials []byte) error {
+	args := []string{"-worker"}
+	if configPath != "" {
+		args = append(args, "-config", configPath)
+	}
+	if err := deferred.Spawn(credentials, args...); err != nil {
+		return err
+	}
+
+	// From here on the worker owns the result; a missing pending file only
+	// shortens how long the client waits
+	dcfg := &cfg.OpenVPN.DeferredAuth
+	if dcfg.PendingMethod != "" {
+		if err := auth.Pending(dcfg.Timeout, dcfg.PendingMethod, dcfg.PendingExtra); err != nil {
+			log.Warn("could not write auth pending file", "error", err)
+		}
+	}
+	return nil
+}
+
+// runWorker validates a deferred login, or waits for a web authentication,
+// and writes the result to auth_control_file. A deny is written when the
+// result is not known within the configured timeout.
+func runWorker(log *logger.Logger, cfg *config.Config, username, password, webAuthID string) {
+	auth := deferred.AuthFromEnv()
+	if auth == nil {
+		log.Error("auth_control_file not provided")
+		os.Exit(1)
+	}
+
+	timeout := cfg.OpenVPN.DeferredAuth.Timeout
+	deadline := timeout
+	if webAuthID != "" {
+		// An unfinished web login is denied by authenticate, which counts
+		// it as a failed login; the timer only catches a stuck worker
+		timeout = cfg.OpenVPN.WebAuth.Timeout
+		deadline = timeout + cfg.API.Timeout
+	}
+	time.AfterFunc(deadline, func() {
+		if err := auth.Finish(false); errors.Is(err, deferred.ErrFinished) {
+			// The result was written in the meantime
+			return
+		} else if err != nil {
+			log.Error("failed to write auth control file", "error", err)
+		}
+		log.Warn("authentication rejected", "reason", "deferred_timeout", "timeout", timeout.String())
+		os.Exit(1)
+	})
+	defer func() {
+		if r := recover(); r != nil {
+			log.Error("deferred authentication failed", "panic", r)
+			finish(log, auth, false)
+			os.Exit(1)
+		}
+	}()
+
+	os.Exit(work(log, cfg, auth, timeout, username, password, webAuthID))
+}
+
+// work authenticates within timeout and writes the result to
+// auth_control_file. Returns the exit code.
+func work(log *logger.Logger, cfg *config.Config, auth *deferred.Auth, timeout time.Duration, username, password, webAuthID string) int {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	code := authenticate(ctx, log, cfg, username, password, webAuthID)
+	cancel()
+
+	finish(log, auth, code == 0)
+	return code
+}
+
+// denyAndExit reports a deny for a worker that cannot authenticate at all
+func denyAndExit(log *logger.Logger) {
+	if auth := deferred.AuthFromEnv(); auth != nil {
+		finish(log, auth, false)
+	}
+	os.Exit(1)
+}
+
+func finish(log *logger.Logger, auth *deferred.Auth, allow bool) {
+	if err := auth.Finish(allow); err != nil && !errors.Is(err, deferred.ErrFinished) {
+		log.Error("failed to write auth control file", "error", err)
+	}
+}
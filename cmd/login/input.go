@@ -0,0 +1,64 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// maxCredentialsSize limits the credentials read from a file or stdin.
+// OpenVPN passes at most a username and a password (or token) line.
+const maxCredentialsSize = 16 * 1024
+
+// readCredentials returns the username and password passed by OpenVPN:
+// in the file named by the first argument (via-file) or, without
+// arguments, in the username and password environment variables (via-env)
+func readCredentials(args []string) (string, string, error) {
+	if len(args) > 0 {
+		f, err := os.Open(args[0])
+		if err != nil {
+			return "", "", fmt.Errorf("failed to open auth file: %w", err)
+		}
+		defer func() { _ = f.Close() }()
+		return parseCredentials(f)
+	}
+
+	username, ok := os.LookupEnv("username")
+	if !ok {
+		return "", "", errors.New("no auth file argument and no username environment variable (via-file or via-env)")
+	}
+	password := os.Getenv("password")
+	// Keep the password out of the environment of child processes
+	_ = os.Unsetenv("password")
+
+	if username == "" {
+		return "", "", errors.New("empty username")
+	}
+	return username, password, nil
+}
+
+// parseCredentials reads "username\npassword\n". Line endings may be CRLF;
+// other whitespace is part of the values.
+func parseCredentials(r io.Reader) (string, string, error) {
+	data, err := io.ReadAll(io.LimitReader(r, maxCredentialsSize+1))
+	if err != nil {
+		return "", "", fmt.Errorf("failed to read credentials: %w", err)
+	}
+	if len(data) > maxCredentialsSize {
+		return "", "", fmt.Errorf("credentials exceed %d bytes", maxCredentialsSize)
+	}
+
+	lines := strings.SplitN(string(data), "\n", 3)
+	if len(lines) < 2 {
+		return "", "", errors.New("invalid credentials format")
+	}
+
+	username := strings.TrimSuffix(lines[0], "\r")
+	password := strings.TrimSuffix(lines[1], "\r")
+	if username == "" {
+		return "", "", errors.New("empty username")
+	}
+	return username, password, nil
+}
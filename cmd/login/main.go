@@ -2,22 +2,33 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"log/slog"
+	"net/netip"
 	"os"
-	"strings"
+	"time"
 
+	"github.com/tldr-it-stepankutaj/openvpn-client/internal/access"
 	"github.com/tldr-it-stepankutaj/openvpn-client/internal/api"
 	"github.com/tldr-it-stepankutaj/openvpn-client/internal/config"
+	"github.com/tldr-it-stepankutaj/openvpn-client/internal/deferred"
+	"github.com/tldr-it-stepankutaj/openvpn-client/internal/firewall"
 	"github.com/tldr-it-stepankutaj/openvpn-client/internal/logger"
+	"github.com/tldr-it-stepankutaj/openvpn-client/internal/mfa"
+	"github.com/tldr-it-stepankutaj/openvpn-client/internal/ratelimit"
 )
 
 const programName = "openvpn-login"
 
 func main() {
 	var configPath string
+	var worker bool
+	var webAuthID string
 	flag.StringVar(&configPath, "config", "", "path to configuration file")
 	flag.StringVar(&configPath, "c", "", "path to configuration file (shorthand)")
+	flag.BoolVar(&worker, "worker", false, "run as deferred authentication worker (internal)")
+	flag.StringVar(&webAuthID, "web-auth", "", "web authentication to wait for (internal, with -worker)")
 	flag.Parse()
 
 	// Initialize logger
@@ -27,61 +38,394 @@ func main() {
 		Program: programName,
 	})
 
-	// Get auth file path from positional argument
-	args := flag.Args()
-	if len(args) < 1 {
-		log.Error("password file path not provided")
-		os.Exit(1)
+	// Read the credentials; a deferred worker receives them on stdin
+	var username, password string
+	var err error
+	if worker {
+		username, password, err = parseCredentials(os.Stdin)
+	} else {
+		username, password, err = readCredentials(flag.Args())
 	}
-
-	authFile := args[0]
-
-	// Read the credential file
-	data, err := os.ReadFile(authFile)
 	if err != nil {
-		log.Error("failed to read auth file", "path", authFile, "error", err)
-		os.Exit(1)
-	}
-
-	lines := strings.Split(string(data), "\n")
-	if len(lines) < 2 {
-		log.Error("invalid auth file format", "path", authFile)
+		log.Error("failed to read credentials", "error", err)
+		if worker {
+			denyAndExit(log)
+		}
 		os.Exit(1)
 	}
 
-	username := strings.TrimSpace(lines[0])
-	password := strings.TrimSpace(lines[1])
-
 	userLog := log.WithUser(username)
 
 	// Load configuration
 	cfg, err := config.Load(configPath)
 	if err != nil {
 		userLog.Error("failed to load config", "error", err)
+		if worker {
+			denyAndExit(userLog)
+		}
 		os.Exit(1)
 	}
 
+	if worker {
+		runWorker(userLog, cfg, username, password, webAuthID)
+	}
+
+	// Browser single sign-on for clients that support it; renegotiations
+	// with an auth token are handled without it
+	if cfg.OpenVPN.WebAuth.Enabled && !hasAuthToken(cfg, username, password) {
+		if webAuthSupported() {
+			os.Exit(startWebAuth(userLog, cfg, configPath, username))
+		}
+		if cfg.OpenVPN.WebAuth.Required {
+			userLog.Warn("authentication rejected", "reason", "web_auth_unsupported")
+			writeFailedReason(userLog, "this server requires a client with web authentication support")
+			os.Exit(1)
+		}
+	}
+
+	// Hand the validation to a background worker so OpenVPN is not blocked
+	if cfg.OpenVPN.DeferredAuth.Enabled {
+		if auth := deferred.AuthFromEnv(); auth != nil {
+			if err := startWorker(userLog, cfg, auth, configPath, []byte(username+"\n"+password+"\n")); err != nil {
+				userLog.Warn("deferred authentication unavailable, authenticating synchronously", "error", err)
+			} else {
+				os.Exit(deferred.ExitDeferred)
+			}
+		} else {
+			userLog.Warn("auth_control_file not provided, authenticating synchronously")
+		}
+	}
+
+	os.Exit(authenticate(context.Background(), userLog, cfg, username, password, ""))
+}
+
+// authenticate validates a login, or the result of the web authentication
+// webAuthID, and returns the exit code for OpenVPN
+func authenticate(ctx context.Context, userLog *logger.Logger, cfg *config.Config, username, password, webAuthID string) int {
 	// Create API client
 	client := api.NewClient(&cfg.API)
 
-	ctx := context.Background()
+	// Refuse blocklisted and locked out logins before contacting the API
+	source, limiter, ok := checkLogin(userLog, cfg, username)
+	if !ok {
+		return 1
+	}
+	sourceIP := ""
+	if source.IsValid() {
+		sourceIP = source.String()
+	}
+
+	// Renegotiations with a valid auth token skip the credential check, but
+	// not the access checks below
+	tokenValid, tokenRejected := checkAuthToken(userLog, cfg, username, password)
+	if tokenRejected {
+		return 1
+	}
+	if tokenValid {
+		user, routes, err := tokenUser(ctx, cfg, client, username)
+		if err != nil {
+			userLog.Error("failed to get user for auth token", "error", err)
+			return 1
+		}
+		if code := checkAccess(ctx, userLog, cfg, client, source, user.Username, user, routes); code != 0 {
+			return code
+		}
+		userLog.Info("user authenticated by auth token", "user_id", user.ID)
+		return 0
+	}
+
+	var authResp *api.VpnAuthResponse
+	var err error
+	if webAuthID != "" {
+		// Wait for the browser login to finish
+		authResp, err = waitWebAuth(ctx, userLog, cfg, client, username, webAuthID)
+	} else {
+		// Decode one-time codes from static or dynamic challenge responses
+		challenges := mfa.NewStore(cfg.OpenVPN.SessionDir, cfg.OpenVPN.MFA.ChallengeTTL)
+		var otp string
+		password, otp, err = credentials(challenges, username, password, sourceIP)
+		if err != nil {
+			userLog.Warn("authentication failed", "reason", "invalid_challenge_response", "source_ip", sourceIP, "error", err)
+			if limiter.Enabled() {
+				recordFailure(ctx, userLog, cfg, limiter, sourceIP, username)
+			}
+			return 1
+		}
+		if otp == "" && cfg.OpenVPN.MFA.Required {
+			if cfg.OpenVPN.MFA.Challenge == "dynamic" {
+				// Only a verified password gets a challenge, so guesses
+				// cannot fill the challenge store and are counted
+				authResp, err = client.ValidateVpnUser(ctx, username, password)
+				if err != nil {
+					userLog.Error("authentication error", "error", err)
+					return 1
+				}
+				if !authResp.Valid {
+					userLog.Warn("authentication failed", "message", authResp.Message, "source_ip", sourceIP)
+					if limiter.Enabled() {
+						recordFailure(ctx, userLog, cfg, limiter, sourceIP, username)
+					}
+					return 1
+				}
+			}
+			if !requireOTP(userLog, &cfg.OpenVPN.MFA, challenges, username, password, sourceIP) && limiter.Enabled() {
+				recordFailure(ctx, userLog, cfg, limiter, sourceIP, username)
+			}
+			return 1
+		}
 
-	// Validate credentials
-	authResp, err := client.ValidateVpnUser(ctx, username, password)
+		// Validate credentials
+		if otp != "" {
+			authResp, err = client.ValidateVpnUserOTP(ctx, username, password, otp)
+		} else {
+			authResp, err = client.ValidateVpnUser(ctx, username, password)
+		}
+	}
 	if err != nil {
 		userLog.Error("authentication error", "error", err)
-		os.Exit(1)
+		return 1
 	}
 
 	if !authResp.Valid {
 		if authResp.StatusCode == 429 {
 			userLog.Warn("authentication rejected", "reason", "rate_limited_or_locked", "message", authResp.Message)
 		} else {
-			userLog.Warn("authentication failed", "message", authResp.Message)
+			userLog.Warn("authentication failed", "message", authResp.Message, "source_ip", sourceIP)
 		}
-		os.Exit(1)
+		if limiter.Enabled() {
+			recordFailure(ctx, userLog, cfg, limiter, sourceIP, username)
+		}
+		return 1
+	}
+
+	if limiter.Enabled() {
+		if err := limiter.Success(username); err != nil {
+			userLog.Warn("login limiter unavailable", "error", err)
+		}
+	}
+
+	if code := checkAccess(ctx, userLog, cfg, client, source, username, &authResp.User, nil); code != 0 {
+		return code
 	}
 
 	userLog.Info("user authenticated successfully", "user_id", authResp.User.ID)
-	os.Exit(0)
+	return 0
+}
+
+// checkAccess applies the checks that follow a successful authentication:
+// validity window, certificate binding, source rules and route schedules.
+// routes may be nil to fetch them from the API. Returns the exit code.
+func checkAccess(ctx context.Context, log *logger.Logger, cfg *config.Config, client *api.Client, source netip.Addr, username string, user *api.UserResponse, routes []api.Network) int {
+	// Refuse users outside their validity window
+	now := time.Now()
+	if err := user.CheckValidity(now, cfg.OpenVPN.Skew()); err != nil {
+		log.Warn("authentication rejected", "reason", "outside_validity_window", "error", err)
+		writeFailedReason(log, "account is not valid at this time")
+		return 1
+	}
+
+	// Refuse client certificates that do not belong to the user
+	cert := access.PeerCertFromEnv(0)
+	if err := access.CheckCertBinding(cfg.OpenVPN.CertBinding.Policy, cert, username, user); err != nil {
+		log.Warn("authentication rejected", "reason", "certificate_mismatch", "error", err)
+		writeFailedReason(log, "client certificate does not belong to this user")
+		return 1
+	}
+
+	// Authenticate if using a legacy service account and the API is needed
+	needAPI := routes == nil || (source.IsValid() && cfg.OpenVPN.SourceFilter.GroupRules)
+	if needAPI && !cfg.API.UseToken() {
+		if err := client.Authenticate(ctx, cfg.API.Username, cfg.API.Password); err != nil {
+			log.Error("API authentication failed", "error", err)
+			return 1
+		}
+	}
+
+	// Refuse source addresses outside the user's and groups' allow/deny lists
+	if source.IsValid() {
+		rules, err := access.UserSourceRules(ctx, client, user, cfg.OpenVPN.SourceFilter.GroupRules)
+		if err != nil {
+			log.Error("failed to get source rules", "error", err)
+			return 1
+		}
+		if err := access.CheckSource(source, rules); err != nil {
+			rejectSource(log, source, err)
+			return 1
+		}
+	}
+
+	// Refuse users whose routes are all outside their schedules
+	if routes == nil {
+		var err error
+		if routes, err = client.GetUserRoutes(ctx, user.ID); err != nil {
+			log.Error("failed to check route schedules", "error", err)
+			return 1
+		}
+	}
+	if !scheduleAllows(routes, now) {
+		log.Warn("authentication rejected", "reason", "outside_schedule")
+		writeFailedReason(log, "access is not allowed at this time")
+		return 1
+	}
+	return 0
+}
+
+// checkLogin refuses blocklisted source addresses and locked out source
+// addresses and usernames before the API is contacted, so the limits also
+// hold when the API is unreachable. Returns the source address (invalid
+// when unknown), the limiter and whether the login may continue.
+func checkLogin(log *logger.Logger, cfg *config.Config, username string) (netip.Addr, *ratelimit.Limiter, bool) {
+	limiter := ratelimit.New(&cfg.OpenVPN.LoginLimit)
+
+	source, err := access.SourceFromEnv("untrusted_ip")
+	if err != nil {
+		log.Warn("could not determine source address", "error", err)
+	}
+	if source.IsValid() {
+		invalid, err := access.CheckBlocklist(cfg.OpenVPN.SourceFilter.BlocklistFile, source)
+		if len(invalid) > 0 {
+			log.Warn("ignoring invalid blocklist entries", "entries", invalid)
+		}
+		if err != nil {
+			rejectSource(log, source, err)
+			return source, limiter, false
+		}
+	}
+
+	sourceIP := ""
+	if source.IsValid() {
+		sourceIP = source.String()
+	}
+	if limiter.Enabled() {
+		lockout, err := limiter.Check(sourceIP, username)
+		if err != nil {
+			log.Warn("login limiter unavailable", "error", err)
+		} else if lockout != nil {
+			log.Warn("authentication rejected",
+				"reason", "locked_out",
+				"locked_by", lockout.Key,
+				"locked_until", lockout.Until,
+				"source_ip", sourceIP,
+			)
+			writeFailedReason(log, "too many failed attempts, try again later")
+			return source, limiter, false
+		}
+	}
+	return source, limiter, true
+}
+
+// credentials returns the password and one-time code of a login. The
+// password field may hold a static (SCRV1) or dynamic (CRV1) challenge
+// response instead of a plain password.
+func credentials(challenges *mfa.Store, username, password, sourceIP string) (string, string, error) {
+	if pass, otp, ok, err := mfa.ParseStaticResponse(password); ok {
+		return pass, otp, err
+	}
+	if stateID, otp, ok := mfa.ParseDynamicResponse(password); ok {
+		pass, err := challenges.Consume(stateID, username, sourceIP)
+		return pass, otp, err
+	}
+	return password, "", nil
+}
+
+// requireOTP handles a login without a one-time code, which is rejected. With dynamic
+// challenges the client is asked for the code and answers with a second
+// login carrying the challenge state; the password must have been verified.
+// Returns false if no challenge was issued because the source or username
+// has too many pending challenges, which counts as a failed login.
+func requireOTP(log *logger.Logger, cfg *config.MFAConfig, challenges *mfa.Store, username, password, sourceIP string) bool {
+	if cfg.Challenge != "dynamic" {
+		log.Warn("authentication rejected", "reason", "otp_required", "source_ip", sourceIP)
+		writeFailedReason(log, "one-time code required")
+		return true
+	}
+
+	// The challenge can only reach the client through auth_failed_reason_file
+	if os.Getenv("auth_failed_reason_file") == "" {
+		log.Error("dynamic challenge requires auth_failed_reason_file (OpenVPN 2.6 or newer)")
+		return true
+	}
+
+	stateID, err := challenges.Create(username, sourceIP, password)
+	if errors.Is(err, mfa.ErrTooManyChallenges) {
+		log.Warn("authentication rejected", "reason", "too_many_challenges", "source_ip", sourceIP)
+		writeFailedReason(log, "too many pending one-time code requests, try again later")
+		return false
+	}
+	if err != nil {
+		log.Error("failed to store challenge", "error", err)
+		return true
+	}
+
+	log.Info("one-time code requested", "source_ip", sourceIP)
+	writeFailedReason(log, mfa.ChallengeReason(stateID, username, cfg.ChallengeText, true))
+	return true
+}
+
+// scheduleAllows reports whether at least one of the routes is available
+// at t. Users without routes or with unscheduled routes are allowed.
+func scheduleAllows(routes []api.Network, t time.Time) bool {
+	if len(routes) == 0 {
+		return true
+	}
+
+	for _, route := range routes {
+		if route.Schedule == nil {
+			return true
+		}
+		if active, err := route.Schedule.ActiveAt(t); err == nil && active {
+			return true
+		}
+	}
+	return false
+}
+
+// recordFailure records a failed login and logs (and optionally bans)
+// source addresses and usernames that are now locked out
+func recordFailure(ctx context.Context, log *logger.Logger, cfg *config.Config, limiter *ratelimit.Limiter, sourceIP, username string) {
+	lockouts, err := limiter.Failure(sourceIP, username)
+	if err != nil {
+		log.Warn("login limiter unavailable", "error", err)
+		return
+	}
+
+	for _, lockout := range lockouts {
+		log.Warn("login locked out after repeated failures",
+			"locked_by", lockout.Key,
+			"value", lockout.Value,
+			"locked_until", lockout.Until,
+			"source_ip", sourceIP,
+		)
+
+		banSet := cfg.OpenVPN.LoginLimit.BanSet
+		if lockout.Key != ratelimit.KeyIP || banSet == "" {
+			continue
+		}
+		if err := firewall.BanAddress(ctx, banSet, lockout.Value, cfg.OpenVPN.LoginLimit.Lockout); err != nil {
+			log.Warn("could not add address to ban set", "set", banSet, "source_ip", sourceIP, "error", err)
+		}
+	}
+}
+
+// rejectSource logs a denied source address. Errors other than
+// access.ErrSourceDenied (e.g. an unreadable blocklist) also deny the login.
+func rejectSource(log *logger.Logger, source netip.Addr, err error) {
+	if errors.Is(err, access.ErrSourceDenied) {
+		log.Warn("authentication rejected", "reason", "source_denied", "source_ip", source.String(), "error", err)
+		writeFailedReason(log, "connections from this address are not allowed")
+	} else {
+		log.Error("failed to check source address", "source_ip", source.String(), "error", err)
+	}
+}
+
+// writeFailedReason passes a reason to the client (AUTH_FAILED,<reason>)
+// when OpenVPN provides auth_failed_reason_file
+func writeFailedReason(log *logger.Logger, reason string) {
+	path := os.Getenv("auth_failed_reason_file")
+	if path == "" {
+		return
+	}
+	if err := os.WriteFile(path, []byte(reason), 0600); err != nil {
+		log.Warn("could not write auth failed reason", "path", path, "error", err)
+	}
 }
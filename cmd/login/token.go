@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/tldr-it-stepankutaj/openvpn-client/internal/api"
+	"github.com/tldr-it-stepankutaj/openvpn-client/internal/authtoken"
+	"github.com/tldr-it-stepankutaj/openvpn-client/internal/cache"
+	"github.com/tldr-it-stepankutaj/openvpn-client/internal/config"
+	"github.com/tldr-it-stepankutaj/openvpn-client/internal/logger"
+)
+
+// checkAuthToken checks the auth token of a renegotiation. OpenVPN's own
+// tokens (auth-gen-token ... external-auth) are reported in session_state;
+// tokens pushed by openvpn-connect arrive as password. A valid token only
+// replaces the credential check: the caller still applies the access
+// checks. rejected is set for tokens OpenVPN found expired or invalid.
+func checkAuthToken(log *logger.Logger, cfg *config.Config, username, password string) (valid, rejected bool) {
+	switch state := os.Getenv("session_state"); state {
+	case "Authenticated", "AuthenticatedEmptyUser":
+		log.Info("auth token accepted", "session_state", state)
+		return true, false
+	case "Expired", "ExpiredEmptyUser", "Invalid":
+		// The password is a token, not the user's password
+		log.Warn("authentication rejected", "reason", "auth_token_rejected", "session_state", state)
+		return false, true
+	}
+
+	if !cfg.OpenVPN.AuthToken.Enabled || !authtoken.IsToken(password) {
+		return false, false
+	}
+
+	// A password may look like a token, so a token that does not verify is
+	// treated as a password
+	if err := verifyAuthToken(cfg, username, password); err != nil {
+		log.Info("auth token not accepted, authenticating with password", "error", err)
+		return false, false
+	}
+
+	log.Info("auth token accepted")
+	return true, false
+}
+
+// tokenUser returns the user and routes of a token renegotiation from the
+// caches openvpn-connect fills, falling back to the API. The caller's
+// source, validity, certificate and schedule checks then need no API call
+// unless group source rules are enabled.
+func tokenUser(ctx context.Context, cfg *config.Config, client *api.Client, username string) (*api.UserResponse, []api.Network, error) {
+	if username == "" {
+		// OpenVPN's tokens may be sent without a username (AuthenticatedEmptyUser)
+		username = os.Getenv("common_name")
+	}
+
+	user, userCached := cache.NewUserCache(cfg.OpenVPN.SessionDir, cfg.OpenVPN.UserCacheTTL).Get(username)
+	routes, routesCached := cache.NewRouteCache(cfg.OpenVPN.SessionDir, cfg.OpenVPN.UserCacheTTL).Get(username)
+
+	if (!userCached || !routesCached) && !cfg.API.UseToken() {
+		if err := client.Authenticate(ctx, cfg.API.Username, cfg.API.Password); err != nil {
+			return nil, nil, err
+		}
+	}
+	if !userCached {
+		var err error
+		if user, err = client.GetUserByUsername(ctx, username); err != nil {
+			return nil, nil, err
+		}
+	}
+	if !routesCached {
+		var err error
+		if routes, err = client.GetUserRoutes(ctx, user.ID); err != nil {
+			return nil, nil, err
+		}
+	}
+	if routes == nil {
+		// nil tells checkAccess to fetch the routes
+		routes = []api.Network{}
+	}
+	return user, routes, nil
+}
+
+// verifyAuthToken checks a token pushed by openvpn-connect
+func verifyAuthToken(cfg *config.Config, username, password string) error {
+	signer, err := authtoken.LoadSigner(cfg.OpenVPN.AuthToken.SecretFile)
+	if err != nil {
+		return fmt.Errorf("failed to load auth token secret: %w", err)
+	}
+	return signer.Verify(password, username, time.Now())
+}
+
+// hasAuthToken reports whether the login is a renegotiation with an auth
+// token, either OpenVPN's own or a valid one pushed by openvpn-connect
+func hasAuthToken(cfg *config.Config, username, password string) bool {
+	if state := os.Getenv("session_state"); state != "" && state != "Initial" {
+		return true
+	}
+	return cfg.OpenVPN.AuthToken.Enabled && authtoken.IsToken(password) &&
+		verifyAuthToken(cfg, username, password) == nil
+}
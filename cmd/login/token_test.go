@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/tldr-it-stepankutaj/openvpn-client/internal/api"
+	"github.com/tldr-it-stepankutaj/openvpn-client/internal/authtoken"
+	"github.com/tldr-it-stepankutaj/openvpn-client/internal/cache"
+	"github.com/tldr-it-stepankutaj/openvpn-client/internal/config"
+)
+
+// otherDays returns a schedule that is never active today
+func otherDays() *api.Schedule {
+	today := time.Now().UTC().Weekday()
+	var days []string
+	for day := time.Sunday; day <= time.Saturday; day++ {
+		// Skip yesterday too, in case the test runs at midnight
+		if day != today && day != (today+6)%7 {
+			days = append(days, day.String())
+		}
+	}
+	return &api.Schedule{Windows: []api.ScheduleWindow{{Days: days, Start: "00:00", End: "24:00"}}}
+}
+
+func TestAuthenticateToken(t *testing.T) {
+	alice := api.UserResponse{ID: "1", Username: "alice", IsActive: true}
+	denied := alice
+	denied.SourceDeny = []string{"192.0.2.0/24"}
+	expired := alice
+	validTo := time.Now().Add(-time.Hour)
+	expired.ValidTo = &validTo
+
+	tests := []struct {
+		name         string
+		user         api.UserResponse
+		cached       bool // user and routes from the cache instead of the API
+		routes       []api.Network
+		sessionState string // OpenVPN's own token instead of a pushed one
+		want         int
+	}{
+		{name: "valid", user: alice, want: 0},
+		{name: "valid cached", user: alice, cached: true, routes: []api.Network{{ID: "n1", CIDR: "10.0.0.0/24"}}, want: 0},
+		{name: "source denied", user: denied, want: 1},
+		{name: "source denied cached", user: denied, cached: true, want: 1},
+		{name: "outside schedule", user: alice, cached: true, routes: []api.Network{{ID: "n1", CIDR: "10.0.0.0/24", Schedule: otherDays()}}, want: 1},
+		{name: "outside validity window", user: expired, want: 1},
+		{name: "openvpn token", user: alice, sessionState: "Authenticated", want: 0},
+		{name: "openvpn token source denied", user: denied, sessionState: "Authenticated", want: 1},
+		{name: "openvpn token expired", user: alice, sessionState: "Expired", want: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &fakeWebAuthAPI{user: tt.user}
+			cfg, _, _ := setupWebAuth(t, fake)
+			cfg.OpenVPN.WebAuth = config.WebAuthConfig{}
+
+			secretFile := filepath.Join(t.TempDir(), "auth-token.key")
+			if err := os.WriteFile(secretFile, []byte("0123456789abcdef0123456789abcdef"), 0600); err != nil {
+				t.Fatal(err)
+			}
+			cfg.OpenVPN.AuthToken = config.AuthTokenConfig{Enabled: true, SecretFile: secretFile, Lifetime: time.Hour}
+
+			if tt.cached {
+				if err := cache.NewUserCache(cfg.OpenVPN.SessionDir, time.Hour).Put(&tt.user); err != nil {
+					t.Fatal(err)
+				}
+				if err := cache.NewRouteCache(cfg.OpenVPN.SessionDir, time.Hour).Put("alice", tt.routes); err != nil {
+					t.Fatal(err)
+				}
+				// A cached renegotiation must not need the API
+				fake.user.Username = "nobody"
+			}
+
+			password := "secret"
+			if tt.sessionState != "" {
+				t.Setenv("session_state", tt.sessionState)
+			} else {
+				signer, err := authtoken.LoadSigner(secretFile)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if password, err = signer.Issue("alice", time.Now().Add(time.Hour)); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if code := authenticate(context.Background(), testLogger(), cfg, "alice", password, ""); code != tt.want {
+				t.Errorf("authenticate = %d, want %d", code, tt.want)
+			}
+		})
+	}
+}
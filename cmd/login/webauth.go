@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/tldr-it-stepankutaj/openvpn-client/internal/access"
+	"github.com/tldr-it-stepankutaj/openvpn-client/internal/api"
+	"github.com/tldr-it-stepankutaj/openvpn-client/internal/config"
+	"github.com/tldr-it-stepankutaj/openvpn-client/internal/deferred"
+	"github.com/tldr-it-stepankutaj/openvpn-client/internal/logger"
+)
+
+// webAuthMethod is the pending auth method for WEB_AUTH URLs (IV_SSO)
+const webAuthMethod = "webauth"
+
+// spawn starts the web authentication worker; replaced in tests
+var spawn = deferred.Spawn
+
+// webAuthSupported reports whether the client announced web authentication
+func webAuthSupported() bool {
+	for _, method := range strings.Split(os.Getenv("IV_SSO"), ",") {
+		if method == webAuthMethod {
+			return true
+		}
+	}
+	return false
+}
+
+// startWebAuth obtains a login URL from the API, passes it to the client
+// through auth_pending_file and leaves the result to a worker polling the
+// API. The source and login limit checks run first, so refused clients
+// cannot start browser logins. Returns the exit code for OpenVPN.
+func startWebAuth(log *logger.Logger, cfg *config.Config, configPath, username string) int {
+	auth := deferred.AuthFromEnv()
+	if auth == nil {
+		log.Error("web authentication requires auth_control_file (OpenVPN 2.6 or newer)")
+		return 1
+	}
+
+	source, limiter, ok := checkLogin(log, cfg, username)
+	if !ok {
+		return 1
+	}
+	sourceIP := ""
+	if source.IsValid() {
+		sourceIP = source.String()
+	}
+
+	ctx := context.Background()
+	client := api.NewClient(&cfg.API)
+
+	// Refuse source addresses outside the user's and groups' allow/deny
+	// lists; unknown users count as failed logins
+	if source.IsValid() {
+		user, err := client.GetUserByUsername(ctx, username)
+		if errors.Is(err, api.ErrUserNotFound) {
+			log.Warn("authentication failed", "reason", "user_not_found", "source_ip", sourceIP)
+			if limiter.Enabled() {
+				recordFailure(ctx, log, cfg, limiter, sourceIP, username)
+			}
+			return 1
+		}
+		if err != nil {
+			log.Error("failed to get user", "error", err)
+			return 1
+		}
+		rules, err := access.UserSourceRules(ctx, client, user, cfg.OpenVPN.SourceFilter.GroupRules)
+		if err != nil {
+			log.Error("failed to get source rules", "error", err)
+			return 1
+		}
+		if err := access.CheckSource(source, rules); err != nil {
+			rejectSource(log, source, err)
+			return 1
+		}
+	}
+
+	session, err := client.StartWebAuth(ctx, username, sourceIP)
+	if err != nil {
+		log.Error("failed to start web authentication", "error", err)
+		return 1
+	}
+
+	timeout := cfg.OpenVPN.WebAuth.Timeout
+	if err := auth.Pending(timeout, webAuthMethod, "WEB_AUTH::"+session.URL); err != nil {
+		log.Error("failed to write auth pending file", "error", err)
+		return 1
+	}
+
+	args := []string{"-worker", "-web-auth", session.ID}
+	if configPath != "" {
+		args = append(args, "-config", configPath)
+	}
+	if err := spawn([]byte(username+"\n\n"), args...); err != nil {
+		log.Error("failed to start web authentication worker", "error", err)
+		return 1
+	}
+
+	log.Info("web authentication started", "web_auth_id", session.ID, "source_ip", sourceIP)
+	return deferred.ExitDeferred
+}
+
+// waitWebAuth polls the API until the browser login is approved, denied or
+// expired, or ctx ends. A login not finished before the deadline of ctx is
+// reported as invalid, so it counts as a failed login like a denied one.
+func waitWebAuth(ctx context.Context, log *logger.Logger, cfg *config.Config, client *api.Client, username, sessionID string) (*api.VpnAuthResponse, error) {
+	ticker := time.NewTicker(cfg.OpenVPN.WebAuth.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		status, err := client.GetWebAuthStatus(ctx, sessionID)
+		if err != nil {
+			log.Warn("web authentication status unavailable", "web_auth_id", sessionID, "error", err)
+		} else {
+			switch status.Status {
+			case api.WebAuthApproved:
+				// The browser login must be for the user OpenVPN authenticates
+				if status.User.Username != username {
+					return &api.VpnAuthResponse{Valid: false, Message: "web login for another user: " + status.User.Username}, nil
+				}
+				return &api.VpnAuthResponse{Valid: true, User: status.User}, nil
+			case api.WebAuthDenied, api.WebAuthExpired:
+				msg := status.Message
+				if msg == "" {
+					msg = "web authentication " + status.Status
+				}
+				return &api.VpnAuthResponse{Valid: false, Message: msg}, nil
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return &api.VpnAuthResponse{Valid: false, Message: "web authentication timed out"}, nil
+			}
+			return nil, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
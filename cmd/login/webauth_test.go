@@ -0,0 +1,206 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/tldr-it-stepankutaj/openvpn-client/internal/api"
+	"github.com/tldr-it-stepankutaj/openvpn-client/internal/config"
+	"github.com/tldr-it-stepankutaj/openvpn-client/internal/deferred"
+	"github.com/tldr-it-stepankutaj/openvpn-client/internal/logger"
+	"github.com/tldr-it-stepankutaj/openvpn-client/internal/ratelimit"
+)
+
+// fakeWebAuthAPI serves the web authentication, user and routes endpoints.
+// status is the web login's state; started counts StartWebAuth calls.
+type fakeWebAuthAPI struct {
+	user    api.UserResponse
+	status  api.WebAuthStatus
+	started atomic.Int32
+}
+
+func (f *fakeWebAuthAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	switch {
+	case r.Method == http.MethodPost && r.URL.Path == "/api/v1/vpn-auth/web-auth":
+		f.started.Add(1)
+		_ = json.NewEncoder(w).Encode(api.WebAuthSession{ID: "s1", URL: "https://sso.example/login/s1"})
+	case r.URL.Path == "/api/v1/vpn-auth/web-auth/s1":
+		_ = json.NewEncoder(w).Encode(f.status)
+	case r.URL.Path == "/api/v1/vpn-auth/users/by-username/"+f.user.Username:
+		_ = json.NewEncoder(w).Encode(f.user)
+	case r.URL.Path == "/api/v1/vpn-auth/users/"+f.user.ID+"/routes":
+		_ = json.NewEncoder(w).Encode(api.RoutesResponse{})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// setupWebAuth starts the fake API and points OpenVPN's environment at
+// temporary auth_pending_file and auth_control_file
+func setupWebAuth(t *testing.T, fake *fakeWebAuthAPI) (*config.Config, string, string) {
+	t.Helper()
+
+	srv := httptest.NewServer(fake)
+	t.Cleanup(srv.Close)
+
+	dir := t.TempDir()
+	pendingFile := filepath.Join(dir, "auth_pending")
+	controlFile := filepath.Join(dir, "auth_control")
+	t.Setenv("auth_pending_file", pendingFile)
+	t.Setenv("auth_control_file", controlFile)
+	t.Setenv("auth_failed_reason_file", filepath.Join(dir, "auth_failed_reason"))
+	t.Setenv("untrusted_ip", "192.0.2.10")
+
+	cfg := &config.Config{}
+	cfg.API = config.APIConfig{BaseURL: srv.URL, Token: "test", Timeout: 5 * time.Second}
+	cfg.OpenVPN.SessionDir = dir
+	cfg.OpenVPN.WebAuth = config.WebAuthConfig{Enabled: true, Timeout: 300 * time.Second, PollInterval: 10 * time.Millisecond}
+	cfg.OpenVPN.LoginLimit = config.LoginLimitConfig{
+		MaxFailuresPerUser: 1,
+		Window:             time.Minute,
+		Lockout:            time.Minute,
+		StateFile:          filepath.Join(dir, "login-limit.json"),
+	}
+	cfg.OpenVPN.SourceFilter.BlocklistFile = filepath.Join(dir, "blocklist")
+	return cfg, pendingFile, controlFile
+}
+
+func testLogger() *logger.Logger {
+	return logger.New(logger.Options{Output: io.Discard, JSON: true, Program: programName})
+}
+
+func lockedOut(t *testing.T, cfg *config.Config, username string) bool {
+	t.Helper()
+	lockout, err := ratelimit.New(&cfg.OpenVPN.LoginLimit).Check("192.0.2.10", username)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return lockout != nil
+}
+
+func TestStartWebAuth(t *testing.T) {
+	fake := &fakeWebAuthAPI{user: api.UserResponse{ID: "1", Username: "alice", IsActive: true}}
+	cfg, pendingFile, _ := setupWebAuth(t, fake)
+
+	var spawned []string
+	spawn = func(stdin []byte, args ...string) error {
+		spawned = args
+		return nil
+	}
+	t.Cleanup(func() { spawn = deferred.Spawn })
+
+	if code := startWebAuth(testLogger(), cfg, "", "alice"); code != deferred.ExitDeferred {
+		t.Fatalf("startWebAuth = %d, want %d", code, deferred.ExitDeferred)
+	}
+
+	data, err := os.ReadFile(pendingFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "300\nwebauth\nWEB_AUTH::https://sso.example/login/s1\n"; string(data) != want {
+		t.Errorf("auth_pending_file = %q, want %q", data, want)
+	}
+	if len(spawned) < 3 || spawned[1] != "-web-auth" || spawned[2] != "s1" {
+		t.Errorf("worker args = %v, want -worker -web-auth s1", spawned)
+	}
+}
+
+func TestStartWebAuthChecksFirst(t *testing.T) {
+	tests := []struct {
+		name    string
+		user    api.UserResponse
+		prepare func(t *testing.T, cfg *config.Config)
+	}{
+		{
+			name: "blocklisted",
+			user: api.UserResponse{ID: "1", Username: "alice", IsActive: true},
+			prepare: func(t *testing.T, cfg *config.Config) {
+				if err := os.WriteFile(cfg.OpenVPN.SourceFilter.BlocklistFile, []byte("192.0.2.0/24\n"), 0600); err != nil {
+					t.Fatal(err)
+				}
+			},
+		},
+		{
+			name: "locked out",
+			user: api.UserResponse{ID: "1", Username: "alice", IsActive: true},
+			prepare: func(t *testing.T, cfg *config.Config) {
+				if _, err := ratelimit.New(&cfg.OpenVPN.LoginLimit).Failure("192.0.2.10", "alice"); err != nil {
+					t.Fatal(err)
+				}
+			},
+		},
+		{
+			name:    "source denied",
+			user:    api.UserResponse{ID: "1", Username: "alice", IsActive: true, SourceDeny: []string{"192.0.2.0/24"}},
+			prepare: func(*testing.T, *config.Config) {},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &fakeWebAuthAPI{user: tt.user}
+			cfg, pendingFile, _ := setupWebAuth(t, fake)
+			tt.prepare(t, cfg)
+
+			spawn = func([]byte, ...string) error {
+				t.Error("worker started for a refused login")
+				return nil
+			}
+			t.Cleanup(func() { spawn = deferred.Spawn })
+
+			if code := startWebAuth(testLogger(), cfg, "", "alice"); code != 1 {
+				t.Errorf("startWebAuth = %d, want 1", code)
+			}
+			if n := fake.started.Load(); n != 0 {
+				t.Errorf("StartWebAuth called %d times, want 0", n)
+			}
+			if _, err := os.Stat(pendingFile); !os.IsNotExist(err) {
+				t.Errorf("auth_pending_file written for a refused login")
+			}
+		})
+	}
+}
+
+func TestWebAuthWorker(t *testing.T) {
+	alice := api.UserResponse{ID: "1", Username: "alice", IsActive: true}
+	tests := []struct {
+		name        string
+		status      api.WebAuthStatus
+		wantControl string
+		wantLocked  bool
+	}{
+		{"approved", api.WebAuthStatus{Status: api.WebAuthApproved, User: alice}, "1", false},
+		{"denied", api.WebAuthStatus{Status: api.WebAuthDenied}, "0", true},
+		{"expired", api.WebAuthStatus{Status: api.WebAuthExpired}, "0", true},
+		{"another user", api.WebAuthStatus{Status: api.WebAuthApproved, User: api.UserResponse{ID: "2", Username: "bob", IsActive: true}}, "0", true},
+		{"timeout", api.WebAuthStatus{Status: api.WebAuthPending}, "0", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &fakeWebAuthAPI{user: alice, status: tt.status}
+			cfg, _, controlFile := setupWebAuth(t, fake)
+
+			work(testLogger(), cfg, deferred.AuthFromEnv(), 200*time.Millisecond, "alice", "", "s1")
+
+			data, err := os.ReadFile(controlFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != tt.wantControl {
+				t.Errorf("auth_control_file = %q, want %q", data, tt.wantControl)
+			}
+			if got := lockedOut(t, cfg, "alice"); got != tt.wantLocked {
+				t.Errorf("failure recorded = %v, want %v", got, tt.wantLocked)
+			}
+		})
+	}
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/tldr-it-stepankutaj/openvpn-client/internal/api"
+	"github.com/tldr-it-stepankutaj/openvpn-client/internal/config"
+	"github.com/tldr-it-stepankutaj/openvpn-client/internal/logger"
+	"github.com/tldr-it-stepankutaj/openvpn-client/internal/pki"
+	"github.com/tldr-it-stepankutaj/openvpn-client/internal/utils"
+)
+
+// issuer issues and renews client certificates of API users
+type issuer struct {
+	cfg    *config.Config
+	log    *logger.Logger
+	client *api.Client
+
+	dryRun     bool
+	diffFormat string
+	force      bool
+}
+
+// issuance is a certificate issued (or to be issued) by this run
+type issuance struct {
+	Username string     `json:"username"`
+	Action   string     `json:"action"`           // "issue", "renew" or "reissue"
+	Serial   string     `json:"serial,omitempty"` // empty in dry-run mode
+	Expires  *time.Time `json:"expires,omitempty"`
+}
+
+// diff lists the certificates issued by this run
+type diff struct {
+	Issued []issuance `json:"issued"`
+}
+
+// run issues certificates for the named users, or all active users, and
+// returns the process exit code
+func (r *issuer) run(ctx context.Context, all bool, usernames []string) int {
+	log := r.log
+	pkiCfg := &r.cfg.PKI
+
+	// Serialise index updates with openvpn-crl and openvpn-profile
+	if !r.dryRun {
+		unlock, err := pki.LockIndex(pkiCfg.IndexFile)
+		if err != nil {
+			log.Error("failed to lock certificate index", "file", pkiCfg.IndexFile, "error", err)
+			return exitError
+		}
+		defer unlock()
+	}
+
+	idx, err := pki.LoadIndex(pkiCfg.IndexFile)
+	if errors.Is(err, os.ErrNotExist) {
+		idx = &pki.Index{}
+	} else if err != nil {
+		log.Error("failed to load certificate index", "file", pkiCfg.IndexFile, "error", err)
+		return exitError
+	}
+
+	// Authenticate if using a legacy service account
+	if !r.cfg.API.UseToken() {
+		if err := r.client.Authenticate(ctx, r.cfg.API.Username, r.cfg.API.Password); err != nil {
+			log.Error("API authentication failed", "error", err)
+			return exitError
+		}
+	}
+
+	now := time.Now()
+	users, failed, err := r.users(ctx, all, usernames, now)
+	if err != nil {
+		log.Error("failed to get users", "error", err)
+		return exitError
+	}
+
+	is := pki.NewIssuer(pkiCfg, idx)
+	d := &diff{Issued: []issuance{}}
+	reissued := false
+	for _, username := range users {
+		userLog := log.WithUser(username)
+
+		if r.dryRun {
+			action, _, err := is.Plan(username, now, r.force)
+			if err != nil {
+				userLog.Error("failed to plan certificate", "error", err)
+				failed++
+				continue
+			}
+			if action != pki.ActionKeep {
+				d.Issued = append(d.Issued, issuance{Username: username, Action: action})
+			}
+			continue
+		}
+
+		action, issued, err := is.Ensure(username, now, r.force)
+		if err != nil {
+			userLog.Error("failed to issue certificate", "action", action, "error", err)
+			failed++
+			continue
+		}
+		if action == pki.ActionKeep {
+			continue
+		}
+
+		serial := strings.ToUpper(issued.Cert.SerialNumber.Text(16))
+		d.Issued = append(d.Issued, issuance{Username: username, Action: action, Serial: serial, Expires: &issued.Cert.NotAfter})
+		userLog.Info("certificate issued", "action", action, "serial", serial, "expires", issued.Cert.NotAfter)
+		reissued = reissued || action == pki.ActionReissue
+	}
+
+	code := exitOK
+	if failed > 0 {
+		code = exitError
+		if all {
+			code = exitPartial
+		}
+	}
+
+	// Dry run - print the diff
+	if r.dryRun {
+		log.Info("dry run mode - printing diff", "issued", len(d.Issued))
+		if err := utils.PrintDiff(os.Stdout, d, r.diffFormat); err != nil {
+			log.Error("failed to print diff", "error", err)
+			return exitError
+		}
+		return code
+	}
+
+	// Reissued certificates were revoked, publish them right away
+	if reissued {
+		if err := is.UpdateCRL(now); err != nil {
+			log.Error("failed to update CRL", "file", pkiCfg.CRLFile, "error", err)
+			return exitError
+		}
+	}
+
+	log.Info("certificates updated", "issued", len(d.Issued), "failed", failed)
+
+	// Machine-readable diff for change-review pipelines
+	if r.diffFormat == "json" {
+		if err := utils.PrintDiff(os.Stdout, d, r.diffFormat); err != nil {
+			log.Warn("failed to print diff", "error", err)
+		}
+	}
+	return code
+}
+
+// users returns the active, unexpired users to issue certificates for and
+// the number of named users that were refused
+func (r *issuer) users(ctx context.Context, all bool, usernames []string, now time.Time) ([]string, int, error) {
+	skew := r.cfg.OpenVPN.Skew()
+
+	if all {
+		active, err := r.client.GetAllActiveUsers(ctx)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		var result []string
+		for _, user := range active {
+			if !user.IsActive || errors.Is(user.CheckValidity(now, skew), api.ErrExpired) {
+				continue
+			}
+			if err := pki.ValidCN(user.Username); err != nil {
+				r.log.WithUser(user.Username).Warn("skipping user", "error", err)
+				continue
+			}
+			result = append(result, user.Username)
+		}
+		return result, 0, nil
+	}
+
+	var result []string
+	failed := 0
+	for _, username := range usernames {
+		userLog := r.log.WithUser(username)
+		if err := pki.ValidCN(username); err != nil {
+			userLog.Error("invalid username", "error", err)
+			failed++
+			continue
+		}
+
+		user, err := r.client.GetUserByUsername(ctx, username)
+		switch {
+		case err != nil:
+			userLog.Error("failed to get user", "error", err)
+		case !user.IsActive:
+			userLog.Error("user is inactive")
+		case errors.Is(user.CheckValidity(now, skew), api.ErrExpired):
+			userLog.Error("user has expired")
+		default:
+			result = append(result, username)
+			continue
+		}
+		failed++
+	}
+	return result, failed, nil
+}
+
+// String returns a human-readable representation of the diff
+func (d *diff) String() string {
+	if len(d.Issued) == 0 {
+		return "no changes\n"
+	}
+
+	var out strings.Builder
+	for _, iss := range d.Issued {
+		out.WriteString(fmt.Sprintf("+ %s %s", iss.Username, iss.Action))
+		if iss.Serial != "" {
+			out.WriteString(fmt.Sprintf(" serial %s expires %s", iss.Serial, iss.Expires.UTC().Format(time.RFC3339)))
+		}
+		out.WriteString("\n")
+	}
+	out.WriteString(fmt.Sprintf("%d certificates issued\n", len(d.Issued)))
+	return out.String()
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log/slog"
+	"os"
+
+	"github.com/tldr-it-stepankutaj/openvpn-client/internal/api"
+	"github.com/tldr-it-stepankutaj/openvpn-client/internal/config"
+	"github.com/tldr-it-stepankutaj/openvpn-client/internal/logger"
+	"github.com/tldr-it-stepankutaj/openvpn-client/internal/utils"
+)
+
+const programName = "openvpn-pki"
+
+// Exit codes
+const (
+	exitOK      = 0
+	exitError   = 1
+	exitPartial = 4 // certificates could not be issued for some users
+)
+
+func main() {
+	var (
+		configPath string
+		dryRun     bool
+		diffFormat string
+		all        bool
+		force      bool
+	)
+	flag.StringVar(&configPath, "config", "", "path to configuration file")
+	flag.StringVar(&configPath, "c", "", "path to configuration file (shorthand)")
+	flag.BoolVar(&dryRun, "dry-run", false, "print certificates to issue without issuing them")
+	flag.BoolVar(&dryRun, "n", false, "print certificates to issue without issuing them (shorthand)")
+	flag.StringVar(&diffFormat, "diff-format", "text", "diff output format: text or json")
+	flag.BoolVar(&all, "all", false, "issue and renew certificates of all active users")
+	flag.BoolVar(&force, "force", false, "reissue certificates of the named users, revoking the current ones")
+	flag.Parse()
+
+	// Initialize logger
+	log := logger.New(logger.Options{
+		Level:   slog.LevelInfo,
+		JSON:    true,
+		Program: programName,
+	})
+
+	if !utils.ValidDiffFormat(diffFormat) {
+		log.Error("invalid diff format", "format", diffFormat)
+		os.Exit(exitError)
+	}
+
+	if all == (flag.NArg() > 0) {
+		log.Error("usage: openvpn-pki [-c config] [-n] [-force] <username>... | -all")
+		os.Exit(exitError)
+	}
+	if all && force {
+		log.Error("--force cannot be combined with --all")
+		os.Exit(exitError)
+	}
+
+	// Load configuration
+	cfg, err := config.Load(configPath)
+	if err != nil {
+		log.Error("failed to load config", "error", err)
+		os.Exit(exitError)
+	}
+
+	is := &issuer{
+		cfg:        cfg,
+		log:        log,
+		client:     api.NewClient(&cfg.API),
+		dryRun:     dryRun,
+		diffFormat: diffFormat,
+		force:      force,
+	}
+	os.Exit(is.run(context.Background(), all, flag.Args()))
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/tldr-it-stepankutaj/openvpn-client/internal/api"
+	"github.com/tldr-it-stepankutaj/openvpn-client/internal/config"
+	"github.com/tldr-it-stepankutaj/openvpn-client/internal/logger"
+	"github.com/tldr-it-stepankutaj/openvpn-client/internal/pki"
+	"github.com/tldr-it-stepankutaj/openvpn-client/internal/profile"
+	"github.com/tldr-it-stepankutaj/openvpn-client/internal/utils"
+)
+
+const programName = "openvpn-profile"
+
+// Exit codes
+const (
+	exitOK      = 0
+	exitError   = 1
+	exitPartial = 4 // profiles could not be written for some users
+)
+
+func main() {
+	var (
+		configPath string
+		proto      string
+		output     string
+		all        bool
+		issue      bool
+	)
+	flag.StringVar(&configPath, "config", "", "path to configuration file")
+	flag.StringVar(&configPath, "c", "", "path to configuration file (shorthand)")
+	flag.StringVar(&proto, "proto", "", "profile variant: udp or tcp (overrides profile.proto)")
+	flag.StringVar(&output, "o", "", "output file, or output directory with -all (default: stdout / profile.output_dir)")
+	flag.BoolVar(&all, "all", false, "write one profile per active user")
+	flag.BoolVar(&issue, "issue", false, "issue or renew client certificates from the local CA before rendering")
+	flag.Parse()
+
+	// Initialize logger
+	log := logger.New(logger.Options{
+		Level:   slog.LevelInfo,
+		JSON:    true,
+		Program: programName,
+	})
+
+	if !all && flag.NArg() != 1 {
+		log.Error("usage: openvpn-profile [-c config] [-proto udp|tcp] [-o file] <username> | -all [-o dir]")
+		os.Exit(exitError)
+	}
+
+	// Load configuration
+	cfg, err := config.Load(configPath)
+	if err != nil {
+		log.Error("failed to load config", "error", err)
+		os.Exit(exitError)
+	}
+
+	if proto == "" {
+		proto = cfg.Profile.Proto
+	}
+	renderer, err := profile.NewRenderer(cfg, proto)
+	if err != nil {
+		log.Error("failed to load profile template", "error", err)
+		os.Exit(exitError)
+	}
+
+	g := &generator{
+		cfg:      cfg,
+		log:      log,
+		client:   api.NewClient(&cfg.API),
+		renderer: renderer,
+	}
+
+	if issue && !cfg.Profile.ClientCerts {
+		log.Error("--issue requires profile.client_certs")
+		os.Exit(exitError)
+	}
+
+	os.Exit(g.run(all, issue, output))
+}
+
+// generator renders profiles of API users
+type generator struct {
+	cfg      *config.Config
+	log      *logger.Logger
+	client   *api.Client
+	renderer *profile.Renderer
+	issuer   *pki.Issuer // nil unless -issue
+}
+
+// run writes the profile of the user named on the command line or, with
+// all, of every active user and returns the process exit code
+func (g *generator) run(all, issue bool, output string) int {
+	// Client certificates are issued into the index read by openvpn-crl
+	if issue {
+		unlock, err := pki.LockIndex(g.cfg.PKI.IndexFile)
+		if err != nil {
+			g.log.Error("failed to lock certificate index", "file", g.cfg.PKI.IndexFile, "error", err)
+			return exitError
+		}
+		defer unlock()
+
+		idx, err := pki.LoadIndex(g.cfg.PKI.IndexFile)
+		if errors.Is(err, os.ErrNotExist) {
+			idx = &pki.Index{}
+		} else if err != nil {
+			g.log.Error("failed to load certificate index", "file", g.cfg.PKI.IndexFile, "error", err)
+			return exitError
+		}
+		g.issuer = pki.NewIssuer(&g.cfg.PKI, idx)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), g.cfg.API.Timeout)
+	defer cancel()
+
+	if !g.cfg.API.UseToken() {
+		if err := g.client.Authenticate(ctx, g.cfg.API.Username, g.cfg.API.Password); err != nil {
+			g.log.Error("API authentication failed", "error", err)
+			return exitError
+		}
+	}
+
+	if all {
+		dir := output
+		if dir == "" {
+			dir = g.cfg.Profile.OutputDir
+		}
+		return g.writeAll(ctx, dir)
+	}
+	return g.writeOne(ctx, flag.Arg(0), output)
+}
+
+// render issues the user's certificate if needed and renders the profile
+func (g *generator) render(username string, now time.Time) ([]byte, error) {
+	if g.issuer != nil {
+		action, issued, err := g.issuer.Ensure(username, now, false)
+		if err != nil {
+			return nil, fmt.Errorf("failed to issue certificate: %w", err)
+		}
+		if action != pki.ActionKeep {
+			g.log.WithUser(username).Info("certificate issued",
+				"action", action,
+				"serial", strings.ToUpper(issued.Cert.SerialNumber.Text(16)),
+				"expires", issued.Cert.NotAfter,
+			)
+		}
+	}
+	return g.renderer.Render(username)
+}
+
+// writeOne renders the profile of a single user to path, or to stdout
+func (g *generator) writeOne(ctx context.Context, username, path string) int {
+	userLog := g.log.WithUser(username)
+
+	user, err := g.client.GetUserByUsername(ctx, username)
+	if err != nil {
+		userLog.Error("failed to get user", "error", err)
+		return exitError
+	}
+	if !user.IsActive {
+		userLog.Error("user is inactive")
+		return exitError
+	}
+	now := time.Now()
+	if err := user.CheckValidity(now, g.cfg.OpenVPN.Skew()); errors.Is(err, api.ErrExpired) {
+		userLog.Error("user has expired", "error", err)
+		return exitError
+	}
+
+	data, err := g.render(user.Username, now)
+	if err != nil {
+		userLog.Error("failed to render profile", "error", err)
+		return exitError
+	}
+
+	if path == "" {
+		if _, err := os.Stdout.Write(data); err != nil {
+			return exitError
+		}
+		return exitOK
+	}
+	if err := utils.WriteFileAtomic(path, data, 0600); err != nil {
+		userLog.Error("failed to write profile", "file", path, "error", err)
+		return exitError
+	}
+	userLog.Info("profile written", "file", path, "proto", g.renderer.Proto())
+	return exitOK
+}
+
+// writeAll renders one profile per active user into dir
+func (g *generator) writeAll(ctx context.Context, dir string) int {
+	log := g.log
+	if dir == "" {
+		dir = "."
+	}
+
+	users, err := g.client.GetAllActiveUsers(ctx)
+	if err != nil {
+		log.Error("failed to get users", "error", err)
+		return exitError
+	}
+
+	now := time.Now()
+	written, failed := 0, 0
+	for _, user := range users {
+		userLog := log.WithUser(user.Username)
+		if !user.IsActive {
+			continue
+		}
+		if err := user.CheckValidity(now, g.cfg.OpenVPN.Skew()); errors.Is(err, api.ErrExpired) {
+			continue
+		}
+
+		data, err := g.render(user.Username, now)
+		if err != nil {
+			userLog.Warn("failed to render profile", "error", err)
+			failed++
+			continue
+		}
+
+		path := filepath.Join(dir, profile.FileName(user.Username, g.renderer.Proto()))
+		if err := utils.WriteFileAtomic(path, data, 0600); err != nil {
+			userLog.Warn("failed to write profile", "file", path, "error", err)
+			failed++
+			continue
+		}
+		written++
+	}
+
+	log.Info("profiles written", "dir", dir, "proto", g.renderer.Proto(), "profiles", written, "failed", failed)
+	if failed > 0 {
+		return exitPartial
+	}
+	return exitOK
+}
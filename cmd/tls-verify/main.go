@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"log/slog"
+	"os"
+	"strconv"
+
+	"github.com/tldr-it-stepankutaj/openvpn-client/internal/access"
+	"github.com/tldr-it-stepankutaj/openvpn-client/internal/api"
+	"github.com/tldr-it-stepankutaj/openvpn-client/internal/config"
+	"github.com/tldr-it-stepankutaj/openvpn-client/internal/logger"
+)
+
+const programName = "openvpn-tls-verify"
+
+func main() {
+	var configPath string
+	var sync bool
+	flag.StringVar(&configPath, "config", "", "path to configuration file")
+	flag.StringVar(&configPath, "c", "", "path to configuration file (shorthand)")
+	flag.BoolVar(&sync, "sync", false, "refresh the local certificate list from the API and exit")
+	flag.Parse()
+
+	// Initialize logger
+	log := logger.New(logger.Options{
+		Level:   slog.LevelInfo,
+		JSON:    true,
+		Program: programName,
+	})
+
+	if sync {
+		cfg, err := config.Load(configPath)
+		if err != nil {
+			log.Error("failed to load config", "error", err)
+			os.Exit(1)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), cfg.API.Timeout)
+		defer cancel()
+
+		count, err := syncCertList(ctx, cfg)
+		if err != nil {
+			log.Error("failed to sync certificate list", "error", err)
+			os.Exit(1)
+		}
+		log.Info("certificate list synced", "path", cfg.OpenVPN.CertStatus.ListFile, "certificates", count)
+		os.Exit(0)
+	}
+
+	// OpenVPN calls: <depth> <subject>
+	args := flag.Args()
+	if len(args) < 2 {
+		log.Error("certificate depth and subject not provided")
+		os.Exit(1)
+	}
+
+	depth, err := strconv.Atoi(args[0])
+	if err != nil || depth < 0 {
+		log.Error("invalid certificate depth", "depth", args[0])
+		os.Exit(1)
+	}
+
+	certLog := log.With("depth", depth, "subject", args[1])
+
+	// Load configuration
+	cfg, err := config.Load(configPath)
+	if err != nil {
+		certLog.Error("failed to load config", "error", err)
+		os.Exit(1)
+	}
+
+	// CA certificates must be pinned, if pins are configured
+	if depth > 0 {
+		if err := access.CheckPinnedCA(cfg.OpenVPN.CertStatus.PinnedCAs, depth); err != nil {
+			certLog.Warn("certificate rejected", "reason", "ca_not_pinned", "error", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	statusMode := cfg.OpenVPN.CertStatus.Mode
+	policy := cfg.OpenVPN.CertBinding.Policy
+	if statusMode == "off" && policy == access.CertPolicyIgnore {
+		os.Exit(0)
+	}
+
+	cert := access.PeerCertFromEnv(0)
+	if cert == nil || cert.CommonName == "" {
+		certLog.Warn("certificate rejected", "reason", "no_common_name")
+		os.Exit(1)
+	}
+
+	userLog := certLog.WithUser(cert.CommonName).With("serial", cert.Serial)
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.API.Timeout)
+	defer cancel()
+
+	// Refuse revoked and unknown certificates
+	if statusMode != "off" {
+		status, err := certStatus(ctx, userLog, cfg, cert)
+		if err != nil {
+			userLog.Error("failed to check certificate status", "error", err)
+			os.Exit(1)
+		}
+		if err := access.CheckCertStatus(status, cert); err != nil {
+			reason := "certificate_unknown"
+			switch {
+			case errors.Is(err, access.ErrCertRevoked):
+				reason = "certificate_revoked"
+			case errors.Is(err, access.ErrCertMismatch):
+				reason = "certificate_mismatch"
+			}
+			userLog.Warn("certificate rejected", "reason", reason, "error", err)
+			os.Exit(1)
+		}
+	}
+
+	// Refuse certificates not bound to an active user
+	if policy != access.CertPolicyIgnore {
+		client, err := newClient(ctx, cfg)
+		if err != nil {
+			userLog.Error("API authentication failed", "error", err)
+			os.Exit(1)
+		}
+
+		// The certificate CN must name an active user
+		user, err := client.GetUserByUsername(ctx, cert.CommonName)
+		if err != nil {
+			userLog.Warn("certificate rejected", "reason", "unknown_user", "error", err)
+			os.Exit(1)
+		}
+		userLog = userLog.With("user_id", user.ID)
+
+		if !user.IsActive {
+			userLog.Warn("certificate rejected", "reason", "inactive_user")
+			os.Exit(1)
+		}
+
+		if policy == access.CertPolicyOwned && !access.OwnsCert(user, cert) {
+			userLog.Warn("certificate rejected", "reason", "certificate_not_registered")
+			os.Exit(1)
+		}
+	}
+
+	userLog.Info("certificate accepted")
+	os.Exit(0)
+}
+
+// newClient creates an API client, authenticating a legacy service account
+func newClient(ctx context.Context, cfg *config.Config) (*api.Client, error) {
+	client := api.NewClient(&cfg.API)
+	if !cfg.API.UseToken() {
+		if err := client.Authenticate(ctx, cfg.API.Username, cfg.API.Password); err != nil {
+			return nil, err
+		}
+	}
+	return client, nil
+}
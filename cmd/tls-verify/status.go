@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"time"
+
+	"github.com/tldr-it-stepankutaj/openvpn-client/internal/access"
+	"github.com/tldr-it-stepankutaj/openvpn-client/internal/api"
+	"github.com/tldr-it-stepankutaj/openvpn-client/internal/config"
+	"github.com/tldr-it-stepankutaj/openvpn-client/internal/logger"
+)
+
+// certStatus returns the status of the client certificate from the API
+// (mode "api") or the local certificate list (mode "list"). A stale list is
+// refreshed first; if the API is unavailable, the stale list is used.
+func certStatus(ctx context.Context, log *logger.Logger, cfg *config.Config, cert *access.PeerCert) (*api.CertificateStatus, error) {
+	statusCfg := &cfg.OpenVPN.CertStatus
+
+	if statusCfg.Mode == "api" {
+		client, err := newClient(ctx, cfg)
+		if err != nil {
+			return nil, err
+		}
+		return client.GetCertificateStatus(ctx, cert.Serial, cert.Fingerprint)
+	}
+
+	list, err := access.LoadCertList(statusCfg.ListFile)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Warn("could not read certificate list", "path", statusCfg.ListFile, "error", err)
+	}
+
+	if list == nil || time.Since(list.SyncedAt) > statusCfg.MaxAge {
+		if _, err := syncCertList(ctx, cfg); err != nil {
+			if list == nil {
+				return nil, err
+			}
+			log.Warn("could not refresh certificate list, using stale list",
+				"synced_at", list.SyncedAt, "error", err)
+		} else if list, err = access.LoadCertList(statusCfg.ListFile); err != nil {
+			return nil, err
+		}
+	}
+
+	return list.Lookup(cert), nil
+}
+
+// syncCertList writes the API's certificate list to the local list file and
+// returns the number of certificates
+func syncCertList(ctx context.Context, cfg *config.Config) (int, error) {
+	client, err := newClient(ctx, cfg)
+	if err != nil {
+		return 0, err
+	}
+
+	certificates, err := client.ListCertificates(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	list := &access.CertList{SyncedAt: time.Now().UTC(), Certificates: certificates}
+	if err := list.Save(cfg.OpenVPN.CertStatus.ListFile); err != nil {
+		return 0, err
+	}
+	return len(certificates), nil
+}
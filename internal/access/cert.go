@@ -0,0 +1,107 @@
+package access
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/tldr-it-stepankutaj/openvpn-client/internal/api"
+)
+
+// Certificate binding policies
+const (
+	CertPolicyIgnore = "ignore" // certificates are not bound to users
+	CertPolicyMatch  = "match"  // the certificate CN must equal the username
+	CertPolicyOwned  = "owned"  // the certificate must be registered to the user
+)
+
+// ErrCertMismatch is returned when a client certificate does not belong to
+// the user logging in
+var ErrCertMismatch = errors.New("certificate does not belong to the user")
+
+// PeerCert identifies a client certificate from the OpenVPN environment
+type PeerCert struct {
+	CommonName  string
+	Serial      string // lower-case hex without separators or leading zeros
+	Fingerprint string // SHA-256, lower-case hex without separators
+}
+
+// PeerCertFromEnv reads the certificate at depth from the environment
+// (X509_<depth>_CN, tls_serial_<depth> and tls_digest_sha256_<depth>).
+// Returns nil when OpenVPN passed no certificate.
+func PeerCertFromEnv(depth int) *PeerCert {
+	d := strconv.Itoa(depth)
+	cert := &PeerCert{
+		CommonName:  os.Getenv("X509_" + d + "_CN"),
+		Fingerprint: NormalizeHex(os.Getenv("tls_digest_sha256_" + d)),
+	}
+
+	if serial, ok := new(big.Int).SetString(os.Getenv("tls_serial_"+d), 10); ok {
+		cert.Serial = serial.Text(16)
+	} else {
+		cert.Serial = NormalizeSerial(os.Getenv("tls_serial_hex_" + d))
+	}
+
+	if cert.CommonName == "" && cert.Serial == "" && cert.Fingerprint == "" {
+		return nil
+	}
+	return cert
+}
+
+// NormalizeHex lower-cases hex and removes ":" separators
+func NormalizeHex(s string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(s), ":", ""))
+}
+
+// NormalizeSerial normalizes a hex serial number, also dropping leading zeros
+func NormalizeSerial(s string) string {
+	serial := strings.TrimLeft(NormalizeHex(s), "0")
+	if serial == "" && s != "" {
+		return "0"
+	}
+	return serial
+}
+
+// OwnsCert reports whether cert is registered to user, by serial number or
+// fingerprint
+func OwnsCert(user *api.UserResponse, cert *PeerCert) bool {
+	for _, registered := range user.Certificates {
+		if cert.Serial != "" && NormalizeSerial(registered.Serial) == cert.Serial {
+			return true
+		}
+		if cert.Fingerprint != "" && NormalizeHex(registered.Fingerprint) == cert.Fingerprint {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckCertBinding checks the client certificate against the username and
+// user record under policy. Errors wrap ErrCertMismatch.
+func CheckCertBinding(policy string, cert *PeerCert, username string, user *api.UserResponse) error {
+	switch policy {
+	case "", CertPolicyIgnore:
+		return nil
+	}
+
+	if cert == nil {
+		return fmt.Errorf("%w: no client certificate", ErrCertMismatch)
+	}
+
+	switch policy {
+	case CertPolicyMatch:
+		if cert.CommonName != username {
+			return fmt.Errorf("%w: certificate CN %q", ErrCertMismatch, cert.CommonName)
+		}
+	case CertPolicyOwned:
+		if !OwnsCert(user, cert) {
+			return fmt.Errorf("%w: serial %s not registered", ErrCertMismatch, cert.Serial)
+		}
+	default:
+		return fmt.Errorf("unknown certificate policy %q", policy)
+	}
+	return nil
+}
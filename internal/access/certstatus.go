@@ -0,0 +1,108 @@
+package access
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/tldr-it-stepankutaj/openvpn-client/internal/api"
+	"github.com/tldr-it-stepankutaj/openvpn-client/internal/utils"
+)
+
+var (
+	// ErrCertRevoked is returned for revoked client certificates
+	ErrCertRevoked = errors.New("certificate revoked")
+	// ErrCertUnknown is returned for client certificates the API does not know
+	ErrCertUnknown = errors.New("certificate unknown")
+	// ErrCANotPinned is returned for CA certificates outside the pinned set
+	ErrCANotPinned = errors.New("CA certificate not pinned")
+)
+
+// CertList is a local copy of the API's certificate list, used to check
+// certificates without an API call per handshake
+type CertList struct {
+	SyncedAt     time.Time               `json:"synced_at"`
+	Certificates []api.CertificateStatus `json:"certificates"`
+}
+
+// LoadCertList reads a certificate list written by Save
+func LoadCertList(path string) (*CertList, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var list CertList
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("invalid certificate list %s: %w", path, err)
+	}
+	return &list, nil
+}
+
+// Save writes the list atomically
+func (l *CertList) Save(path string) error {
+	data, err := json.Marshal(l)
+	if err != nil {
+		return err
+	}
+	return utils.WriteFileAtomic(path, data, 0600)
+}
+
+// Lookup returns the status of cert, matched by serial number or
+// fingerprint. Certificates missing from the list are reported unknown.
+func (l *CertList) Lookup(cert *PeerCert) *api.CertificateStatus {
+	for i := range l.Certificates {
+		entry := &l.Certificates[i]
+		if cert.Serial != "" && NormalizeSerial(entry.Serial) == cert.Serial {
+			return entry
+		}
+		if cert.Fingerprint != "" && entry.Fingerprint != "" && NormalizeHex(entry.Fingerprint) == cert.Fingerprint {
+			return entry
+		}
+	}
+	return &api.CertificateStatus{Serial: cert.Serial, Fingerprint: cert.Fingerprint, Status: api.CertificateUnknown}
+}
+
+// CheckCertStatus accepts only valid certificates issued to the certificate's
+// CN. Errors wrap ErrCertRevoked, ErrCertUnknown or ErrCertMismatch.
+func CheckCertStatus(status *api.CertificateStatus, cert *PeerCert) error {
+	switch status.Status {
+	case api.CertificateValid:
+	case api.CertificateRevoked:
+		if status.RevokedAt != nil {
+			return fmt.Errorf("%w at %s", ErrCertRevoked, status.RevokedAt.Format(time.RFC3339))
+		}
+		return ErrCertRevoked
+	case api.CertificateUnknown, "":
+		return ErrCertUnknown
+	default:
+		return fmt.Errorf("%w: status %q", ErrCertUnknown, status.Status)
+	}
+
+	if status.Username != "" && status.Username != cert.CommonName {
+		return fmt.Errorf("%w: issued to %q", ErrCertMismatch, status.Username)
+	}
+	return nil
+}
+
+// CheckPinnedCA checks the CA certificate at depth (tls_digest_sha256_<depth>)
+// against the pinned SHA-256 fingerprints. An empty pin list accepts every CA
+// OpenVPN trusts.
+func CheckPinnedCA(pinned []string, depth int) error {
+	if len(pinned) == 0 {
+		return nil
+	}
+
+	fingerprint := NormalizeHex(os.Getenv("tls_digest_sha256_" + strconv.Itoa(depth)))
+	if fingerprint == "" {
+		return fmt.Errorf("%w: no fingerprint", ErrCANotPinned)
+	}
+	if !slices.ContainsFunc(pinned, func(pin string) bool { return NormalizeHex(pin) == fingerprint }) {
+		return fmt.Errorf("%w: %s", ErrCANotPinned, fingerprint)
+	}
+	return nil
+}
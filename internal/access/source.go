@@ -0,0 +1,176 @@
+package access
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net/netip"
+	"os"
+	"strings"
+
+	"github.com/tldr-it-stepankutaj/openvpn-client/internal/api"
+)
+
+// ErrSourceDenied is returned when a client connects from a source address
+// it is not allowed to use
+var ErrSourceDenied = errors.New("source address not allowed")
+
+// SourceRules are allow and deny lists of source CIDRs from one origin
+type SourceRules struct {
+	Origin string // e.g. "user" or "group developers"
+	Allow  []string
+	Deny   []string
+}
+
+// ParseSourceIP parses a client address as passed by OpenVPN
+func ParseSourceIP(s string) (netip.Addr, error) {
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Addr{}, fmt.Errorf("invalid source address %q", s)
+	}
+	return addr.Unmap(), nil
+}
+
+// SourceFromEnv returns the client address from the OpenVPN environment
+// variable name (e.g. "untrusted_ip"), falling back to its IPv6 variant
+// (e.g. "untrusted_ip6"). Returns an invalid Addr if neither is set.
+func SourceFromEnv(name string) (netip.Addr, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		value = os.Getenv(name + "6")
+	}
+	if value == "" {
+		return netip.Addr{}, nil
+	}
+	return ParseSourceIP(value)
+}
+
+// CheckBlocklist returns an error wrapping ErrSourceDenied if addr is listed
+// in the blocklist file. Invalid lines of the file are returned for reporting.
+func CheckBlocklist(path string, addr netip.Addr) ([]string, error) {
+	blocklist, invalid, err := loadBlocklist(path)
+	if err != nil {
+		return invalid, fmt.Errorf("failed to read blocklist: %w", err)
+	}
+	for _, prefix := range blocklist {
+		if prefix.Contains(addr) {
+			return invalid, fmt.Errorf("%w: blocklisted (%s)", ErrSourceDenied, prefix)
+		}
+	}
+	return invalid, nil
+}
+
+// CheckSource returns an error wrapping ErrSourceDenied if addr is in any
+// deny list, or if allow lists exist and addr is in none of them. Allow
+// lists of all origins are combined.
+func CheckSource(addr netip.Addr, rules []SourceRules) error {
+	hasAllow, allowed := false, false
+	for _, r := range rules {
+		if prefix, ok := matchAny(addr, r.Deny); ok {
+			return fmt.Errorf("%w: denied by %s (%s)", ErrSourceDenied, r.Origin, prefix)
+		}
+		if len(r.Allow) > 0 {
+			hasAllow = true
+			if _, ok := matchAny(addr, r.Allow); ok {
+				allowed = true
+			}
+		}
+	}
+
+	if hasAllow && !allowed {
+		return fmt.Errorf("%w: not in any allow list", ErrSourceDenied)
+	}
+	return nil
+}
+
+// UserSourceRules returns the source rules of the user and, if withGroups
+// is set, of the user's groups
+func UserSourceRules(ctx context.Context, client *api.Client, user *api.UserResponse, withGroups bool) ([]SourceRules, error) {
+	rules := []SourceRules{{Origin: "user", Allow: user.SourceAllow, Deny: user.SourceDeny}}
+	if !withGroups {
+		return rules, nil
+	}
+
+	groups, err := client.GetUserGroups(ctx, user.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user groups: %w", err)
+	}
+	for _, group := range groups {
+		rules = append(rules, SourceRules{Origin: "group " + group.Name, Allow: group.SourceAllow, Deny: group.SourceDeny})
+	}
+	return rules, nil
+}
+
+// loadBlocklist reads a blocklist file with one IP or CIDR per line.
+// Empty lines and "#" comments are ignored. A missing file is an empty
+// blocklist. Invalid lines are skipped and returned for reporting.
+func loadBlocklist(path string) ([]netip.Prefix, []string, error) {
+	if path == "" {
+		return nil, nil, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil, nil
+		}
+		return nil, nil, err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	var prefixes []netip.Prefix
+	var invalid []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		prefix, err := parsePrefix(line)
+		if err != nil {
+			invalid = append(invalid, line)
+			continue
+		}
+		prefixes = append(prefixes, prefix)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, err
+	}
+	return prefixes, invalid, nil
+}
+
+// matchAny returns the first entry of list containing addr.
+// Invalid entries are ignored.
+func matchAny(addr netip.Addr, list []string) (string, bool) {
+	for _, entry := range list {
+		prefix, err := parsePrefix(entry)
+		if err == nil && prefix.Contains(addr) {
+			return entry, true
+		}
+	}
+	return "", false
+}
+
+// parsePrefix parses a CIDR or a single address
+func parsePrefix(s string) (netip.Prefix, error) {
+	if strings.Contains(s, "/") {
+		prefix, err := netip.ParsePrefix(s)
+		if err != nil {
+			return netip.Prefix{}, err
+		}
+		if prefix.Addr().Is4In6() && prefix.Bits() >= 96 {
+			prefix = netip.PrefixFrom(prefix.Addr().Unmap(), prefix.Bits()-96)
+		}
+		return prefix.Masked(), nil
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	addr = addr.Unmap()
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
@@ -0,0 +1,154 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+)
+
+// FeatureUsersIncludeRoutes is advertised by servers supporting
+// GET /api/v1/vpn-auth/users?include=routes
+const FeatureUsersIncludeRoutes = "users_include_routes"
+
+const bulkPageSize = 500
+
+// GetCapabilities returns the features advertised by the API.
+// Servers without the capabilities endpoint yield an empty feature list.
+func (c *Client) GetCapabilities(ctx context.Context) (*CapabilitiesResponse, error) {
+	if c.apiToken == "" {
+		// Legacy service account endpoints have no optional features
+		return &CapabilitiesResponse{}, nil
+	}
+
+	resp, err := c.doRequest(ctx, http.MethodGet, "/api/v1/vpn-auth/capabilities", nil, true)
+	if err != nil {
+		return nil, fmt.Errorf("get capabilities request failed: %w", err)
+	}
+	defer func(Body io.ReadCloser) {
+		err := Body.Close()
+		if err != nil {
+			return
+		}
+	}(resp.Body)
+
+	if resp.StatusCode == http.StatusNotFound {
+		return &CapabilitiesResponse{}, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, c.parseError(resp)
+	}
+
+	var caps CapabilitiesResponse
+	if err := json.NewDecoder(resp.Body).Decode(&caps); err != nil {
+		return nil, fmt.Errorf("failed to decode capabilities: %w", err)
+	}
+	return &caps, nil
+}
+
+// StreamUsersWithRoutes fetches all users together with their routes from
+// the bulk endpoint and calls fn for each user as it is decoded, so memory
+// use does not grow with the number of users. The server is not trusted to
+// leave out inactive users; fn must check IsActive.
+func (c *Client) StreamUsersWithRoutes(ctx context.Context, fn func(*UserWithRoutes) error) error {
+	page := 1
+	for {
+		params := url.Values{}
+		params.Set("include", "routes")
+		params.Set("page", fmt.Sprintf("%d", page))
+		params.Set("page_size", fmt.Sprintf("%d", bulkPageSize))
+
+		totalPages, err := c.streamUsersPage(ctx, "/api/v1/vpn-auth/users?"+params.Encode(), fn)
+		if err != nil {
+			return fmt.Errorf("users page %d: %w", page, err)
+		}
+
+		if page >= totalPages {
+			return nil
+		}
+		page++
+	}
+}
+
+// streamUsersPage decodes a single page and returns the total page count.
+// Pages without pagination metadata are treated as the only page.
+func (c *Client) streamUsersPage(ctx context.Context, path string, fn func(*UserWithRoutes) error) (int, error) {
+	// Bypass conditional request caching - it would buffer the whole body
+	req, err := c.newRequest(ctx, http.MethodGet, path, nil, true)
+	if err != nil {
+		return 0, err
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("get users request failed: %w", err)
+	}
+	defer func(Body io.ReadCloser) {
+		err := Body.Close()
+		if err != nil {
+			return
+		}
+	}(resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, c.parseError(resp)
+	}
+
+	dec := json.NewDecoder(resp.Body)
+	if err := expectDelim(dec, '{'); err != nil {
+		return 0, err
+	}
+
+	totalPages := 1
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return 0, fmt.Errorf("failed to decode users: %w", err)
+		}
+		key, _ := tok.(string)
+
+		switch key {
+		case "users":
+			if err := expectDelim(dec, '['); err != nil {
+				return 0, err
+			}
+			for dec.More() {
+				var user UserWithRoutes
+				if err := dec.Decode(&user); err != nil {
+					return 0, fmt.Errorf("failed to decode user: %w", err)
+				}
+				if err := fn(&user); err != nil {
+					return 0, err
+				}
+			}
+			if err := expectDelim(dec, ']'); err != nil {
+				return 0, err
+			}
+		case "total_pages":
+			if err := dec.Decode(&totalPages); err != nil {
+				return 0, fmt.Errorf("failed to decode total_pages: %w", err)
+			}
+		default:
+			// Skip unknown fields
+			var skip json.RawMessage
+			if err := dec.Decode(&skip); err != nil {
+				return 0, fmt.Errorf("failed to decode users: %w", err)
+			}
+		}
+	}
+
+	return totalPages, expectDelim(dec, '}')
+}
+
+func expectDelim(dec *json.Decoder, delim json.Delim) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return fmt.Errorf("failed to decode users: %w", err)
+	}
+	if d, ok := tok.(json.Delim); !ok || d != delim {
+		return fmt.Errorf("failed to decode users: expected %q, got %v", delim, tok)
+	}
+	return nil
+}
@@ -4,28 +4,49 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
+	"sync"
 	"time"
 
 	"github.com/tldr-it-stepankutaj/openvpn-client/internal/config"
 )
 
 const (
-	headerContentType = "Content-Type"
-	headerVPNToken    = "X-VPN-Token"
-	headerAuth        = "Authorization"
-	contentTypeJSON   = "application/json"
+	headerContentType     = "Content-Type"
+	headerVPNToken        = "X-VPN-Token"
+	headerAuth            = "Authorization"
+	headerETag            = "ETag"
+	headerLastModified    = "Last-Modified"
+	headerIfNoneMatch     = "If-None-Match"
+	headerIfModifiedSince = "If-Modified-Since"
+	contentTypeJSON       = "application/json"
 )
 
+// ErrUserNotFound is returned by GetUserByUsername for unknown users
+var ErrUserNotFound = errors.New("user not found")
+
 // Client represents the API client
 type Client struct {
 	baseURL    string
 	httpClient *http.Client
 	token      string // JWT token (from service account login)
 	apiToken   string // Static API token (from config)
+
+	conditional bool                       // send If-None-Match / If-Modified-Since on GET
+	cacheMu     sync.Mutex                 // guards responses
+	responses   map[string]*cachedResponse // last response per GET path
+}
+
+// cachedResponse is a GET response kept for conditional requests
+type cachedResponse struct {
+	etag         string
+	lastModified string
+	header       http.Header
+	body         []byte
 }
 
 // NewClient creates a new API client
@@ -44,6 +65,20 @@ func NewClient(cfg *config.APIConfig) *Client {
 	return c
 }
 
+// EnableConditionalRequests makes GET requests conditional (ETag /
+// If-Modified-Since) where the API supports it. A 304 response is served
+// from the last cached body, so callers always see a 200 response.
+// Intended for long-running processes such as the firewall daemon.
+func (c *Client) EnableConditionalRequests() {
+	c.cacheMu.Lock()
+	defer c.cacheMu.Unlock()
+
+	c.conditional = true
+	if c.responses == nil {
+		c.responses = make(map[string]*cachedResponse)
+	}
+}
+
 // Authenticate gets a JWT token using service account credentials (legacy)
 func (c *Client) Authenticate(ctx context.Context, username, password string) error {
 	body := map[string]string{
@@ -102,22 +137,7 @@ func (c *Client) ValidateVpnUser(ctx context.Context, username, password string)
 
 	if c.apiToken != "" {
 		// VPN auth endpoint returns VpnAuthResponse
-		if resp.StatusCode != http.StatusOK {
-			msg := "authentication failed"
-			// Parse server error for specific messages (rate limit, lockout, etc.)
-			var errResp ErrorResponse
-			if err := json.NewDecoder(resp.Body).Decode(&errResp); err == nil && errResp.Message != "" {
-				msg = errResp.Message
-			}
-			return &VpnAuthResponse{Valid: false, Message: msg, StatusCode: resp.StatusCode}, nil
-		}
-
-		var authResp VpnAuthResponse
-		if err := json.NewDecoder(resp.Body).Decode(&authResp); err != nil {
-			return nil, fmt.Errorf("failed to decode auth response: %w", err)
-		}
-		authResp.StatusCode = resp.StatusCode
-		return &authResp, nil
+		return decodeVpnAuth(resp)
 	}
 
 	// Legacy login endpoint
@@ -137,6 +157,185 @@ func (c *Client) ValidateVpnUser(ctx context.Context, username, password string)
 	return &VpnAuthResponse{Valid: true, User: loginResp.User}, nil
 }
 
+// ValidateVpnUserOTP validates VPN user credentials together with a TOTP or
+// one-time code. Requires API token authentication.
+func (c *Client) ValidateVpnUserOTP(ctx context.Context, username, password, otp string) (*VpnAuthResponse, error) {
+	if c.apiToken == "" {
+		return nil, fmt.Errorf("one-time code validation requires api.token")
+	}
+
+	body := VpnAuthRequest{
+		Username: username,
+		Password: password,
+		OTP:      otp,
+	}
+
+	resp, err := c.doRequest(ctx, http.MethodPost, "/api/v1/vpn-auth/authenticate-mfa", body, true)
+	if err != nil {
+		return nil, fmt.Errorf("validate user request failed: %w", err)
+	}
+	defer func(Body io.ReadCloser) {
+		err := Body.Close()
+		if err != nil {
+			return
+		}
+	}(resp.Body)
+
+	return decodeVpnAuth(resp)
+}
+
+// decodeVpnAuth decodes a VPN auth endpoint response. Non-200 responses
+// are returned as invalid with the server's message.
+func decodeVpnAuth(resp *http.Response) (*VpnAuthResponse, error) {
+	if resp.StatusCode != http.StatusOK {
+		msg := "authentication failed"
+		// Parse server error for specific messages (rate limit, lockout, etc.)
+		var errResp ErrorResponse
+		if err := json.NewDecoder(resp.Body).Decode(&errResp); err == nil && errResp.Message != "" {
+			msg = errResp.Message
+		}
+		return &VpnAuthResponse{Valid: false, Message: msg, StatusCode: resp.StatusCode}, nil
+	}
+
+	var authResp VpnAuthResponse
+	if err := json.NewDecoder(resp.Body).Decode(&authResp); err != nil {
+		return nil, fmt.Errorf("failed to decode auth response: %w", err)
+	}
+	authResp.StatusCode = resp.StatusCode
+	return &authResp, nil
+}
+
+// GetCertificateStatus looks up a client certificate by serial number
+// and/or SHA-256 fingerprint. Unknown certificates are reported with
+// status CertificateUnknown.
+func (c *Client) GetCertificateStatus(ctx context.Context, serial, fingerprint string) (*CertificateStatus, error) {
+	query := url.Values{}
+	if serial != "" {
+		query.Set("serial", serial)
+	}
+	if fingerprint != "" {
+		query.Set("fingerprint", fingerprint)
+	}
+
+	resp, err := c.doRequest(ctx, http.MethodGet, "/api/v1/vpn-auth/certificates/status?"+query.Encode(), nil, true)
+	if err != nil {
+		return nil, fmt.Errorf("get certificate status request failed: %w", err)
+	}
+	defer func(Body io.ReadCloser) {
+		err := Body.Close()
+		if err != nil {
+			return
+		}
+	}(resp.Body)
+
+	if resp.StatusCode == http.StatusNotFound {
+		return &CertificateStatus{Serial: serial, Fingerprint: fingerprint, Status: CertificateUnknown}, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, c.parseError(resp)
+	}
+
+	var status CertificateStatus
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		return nil, fmt.Errorf("failed to decode certificate status: %w", err)
+	}
+
+	return &status, nil
+}
+
+// ListCertificates returns all client certificates known to the API with
+// their status, for a local revocation list
+func (c *Client) ListCertificates(ctx context.Context) ([]CertificateStatus, error) {
+	resp, err := c.doRequest(ctx, http.MethodGet, "/api/v1/vpn-auth/certificates", nil, true)
+	if err != nil {
+		return nil, fmt.Errorf("list certificates request failed: %w", err)
+	}
+	defer func(Body io.ReadCloser) {
+		err := Body.Close()
+		if err != nil {
+			return
+		}
+	}(resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, c.parseError(resp)
+	}
+
+	var list CertificateListResponse
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return nil, fmt.Errorf("failed to decode certificates: %w", err)
+	}
+
+	return list.Certificates, nil
+}
+
+// StartWebAuth starts a browser login for a VPN user and returns the URL
+// the user has to open. Requires API token authentication.
+func (c *Client) StartWebAuth(ctx context.Context, username, clientIP string) (*WebAuthSession, error) {
+	if c.apiToken == "" {
+		return nil, fmt.Errorf("web authentication requires api.token")
+	}
+
+	body := WebAuthRequest{
+		Username: username,
+		ClientIP: clientIP,
+	}
+
+	resp, err := c.doRequest(ctx, http.MethodPost, "/api/v1/vpn-auth/web-auth", body, true)
+	if err != nil {
+		return nil, fmt.Errorf("start web auth request failed: %w", err)
+	}
+	defer func(Body io.ReadCloser) {
+		err := Body.Close()
+		if err != nil {
+			return
+		}
+	}(resp.Body)
+
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK {
+		return nil, c.parseError(resp)
+	}
+
+	var session WebAuthSession
+	if err := json.NewDecoder(resp.Body).Decode(&session); err != nil {
+		return nil, fmt.Errorf("failed to decode web auth session: %w", err)
+	}
+	if session.ID == "" || session.URL == "" {
+		return nil, fmt.Errorf("web auth session without id or url")
+	}
+
+	return &session, nil
+}
+
+// GetWebAuthStatus returns the state of a browser login
+func (c *Client) GetWebAuthStatus(ctx context.Context, sessionID string) (*WebAuthStatus, error) {
+	resp, err := c.doRequest(ctx, http.MethodGet, "/api/v1/vpn-auth/web-auth/"+url.PathEscape(sessionID), nil, true)
+	if err != nil {
+		return nil, fmt.Errorf("get web auth status request failed: %w", err)
+	}
+	defer func(Body io.ReadCloser) {
+		err := Body.Close()
+		if err != nil {
+			return
+		}
+	}(resp.Body)
+
+	// An unknown session has expired on the server
+	if resp.StatusCode == http.StatusNotFound {
+		return &WebAuthStatus{Status: WebAuthExpired}, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, c.parseError(resp)
+	}
+
+	var status WebAuthStatus
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		return nil, fmt.Errorf("failed to decode web auth status: %w", err)
+	}
+
+	return &status, nil
+}
+
 // GetUserByUsername finds a user by username
 func (c *Client) GetUserByUsername(ctx context.Context, username string) (*UserResponse, error) {
 	// Use VPN-specific endpoint if using an API token
@@ -152,6 +351,9 @@ func (c *Client) GetUserByUsername(ctx context.Context, username string) (*UserR
 			}
 		}(resp.Body)
 
+		if resp.StatusCode == http.StatusNotFound {
+			return nil, fmt.Errorf("%w: %s", ErrUserNotFound, username)
+		}
 		if resp.StatusCode != http.StatusOK {
 			return nil, c.parseError(resp)
 		}
@@ -194,7 +396,7 @@ func (c *Client) GetUserByUsername(ctx context.Context, username string) (*UserR
 		}
 	}
 
-	return nil, fmt.Errorf("user not found: %s", username)
+	return nil, fmt.Errorf("%w: %s", ErrUserNotFound, username)
 }
 
 // GetUserRoutes gets user's allowed networks (routes)
@@ -250,6 +452,36 @@ func (c *Client) GetUserRoutes(ctx context.Context, userID string) ([]Network, e
 	return networks, nil
 }
 
+// GetUserGroups gets user's groups with their networks
+func (c *Client) GetUserGroups(ctx context.Context, userID string) ([]GroupWithNetworks, error) {
+	// Use VPN-specific endpoint if using an API token
+	endpoint := "/api/v1/vpn-auth/users/" + userID + "/groups"
+	if c.apiToken == "" {
+		endpoint = "/api/v1/users/" + userID + "/groups"
+	}
+
+	resp, err := c.doRequest(ctx, http.MethodGet, endpoint, nil, true)
+	if err != nil {
+		return nil, fmt.Errorf("get groups request failed: %w", err)
+	}
+	defer func(Body io.ReadCloser) {
+		err := Body.Close()
+		if err != nil {
+			return
+		}
+	}(resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, c.parseError(resp)
+	}
+
+	var groupsResp GroupsResponse
+	if err := json.NewDecoder(resp.Body).Decode(&groupsResp); err != nil {
+		return nil, fmt.Errorf("failed to decode groups: %w", err)
+	}
+	return groupsResp.Groups, nil
+}
+
 // GetAllActiveUsers gets all active users for firewall rules
 func (c *Client) GetAllActiveUsers(ctx context.Context) ([]UserResponse, error) {
 	// Use VPN-specific endpoint if using an API token
@@ -375,7 +607,53 @@ func (c *Client) DisconnectSession(ctx context.Context, sessionID string, bytesR
 	return nil
 }
 
+// GetActiveSessions returns the user's active sessions across all servers.
+// Returns false if the API has no active-sessions endpoint.
+func (c *Client) GetActiveSessions(ctx context.Context, userID string) ([]VpnSession, bool, error) {
+	if c.apiToken == "" {
+		// Not available with legacy service account endpoints
+		return nil, false, nil
+	}
+
+	resp, err := c.doRequest(ctx, http.MethodGet, "/api/v1/vpn-auth/users/"+url.PathEscape(userID)+"/sessions?active=true", nil, true)
+	if err != nil {
+		return nil, false, fmt.Errorf("get active sessions request failed: %w", err)
+	}
+	defer func(Body io.ReadCloser) {
+		err := Body.Close()
+		if err != nil {
+			return
+		}
+	}(resp.Body)
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, false, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, false, c.parseError(resp)
+	}
+
+	var sessionsResp SessionsResponse
+	if err := json.NewDecoder(resp.Body).Decode(&sessionsResp); err != nil {
+		return nil, false, fmt.Errorf("failed to decode sessions: %w", err)
+	}
+	return sessionsResp.Sessions, true, nil
+}
+
 func (c *Client) doRequest(ctx context.Context, method, path string, body interface{}, auth bool) (*http.Response, error) {
+	req, err := c.newRequest(ctx, method, path, body, auth)
+	if err != nil {
+		return nil, err
+	}
+
+	if method == http.MethodGet && c.conditional {
+		return c.doConditional(req, path)
+	}
+
+	return c.httpClient.Do(req)
+}
+
+func (c *Client) newRequest(ctx context.Context, method, path string, body interface{}, auth bool) (*http.Request, error) {
 	var bodyReader io.Reader
 	if body != nil {
 		jsonBody, err := json.Marshal(body)
@@ -400,7 +678,62 @@ func (c *Client) doRequest(ctx context.Context, method, path string, body interf
 		}
 	}
 
-	return c.httpClient.Do(req)
+	return req, nil
+}
+
+// doConditional performs a GET with cache validators and serves 304
+// responses from the cached body
+func (c *Client) doConditional(req *http.Request, path string) (*http.Response, error) {
+	c.cacheMu.Lock()
+	cached := c.responses[path]
+	c.cacheMu.Unlock()
+
+	if cached != nil {
+		if cached.etag != "" {
+			req.Header.Set(headerIfNoneMatch, cached.etag)
+		}
+		if cached.lastModified != "" {
+			req.Header.Set(headerIfModifiedSince, cached.lastModified)
+		}
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode == http.StatusNotModified && cached != nil {
+		_ = resp.Body.Close()
+		resp.StatusCode = http.StatusOK
+		resp.Status = http.StatusText(http.StatusOK)
+		resp.Header = cached.header
+		resp.Body = io.NopCloser(bytes.NewReader(cached.body))
+		return resp, nil
+	}
+
+	etag := resp.Header.Get(headerETag)
+	lastModified := resp.Header.Get(headerLastModified)
+	if resp.StatusCode != http.StatusOK || (etag == "" && lastModified == "") {
+		return resp, nil
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	c.cacheMu.Lock()
+	c.responses[path] = &cachedResponse{
+		etag:         etag,
+		lastModified: lastModified,
+		header:       resp.Header.Clone(),
+		body:         body,
+	}
+	c.cacheMu.Unlock()
+
+	return resp, nil
 }
 
 func (c *Client) parseError(resp *http.Response) error {
@@ -0,0 +1,228 @@
+package api
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+const minutesPerDay = 24 * 60
+
+// Schedule limits when a group or route is available
+type Schedule struct {
+	Timezone string           `json:"timezone"` // IANA time zone, default UTC
+	Windows  []ScheduleWindow `json:"windows"`
+}
+
+// ScheduleWindow is a weekly time window. An end before the start spans
+// midnight into the next day.
+type ScheduleWindow struct {
+	Days  []string `json:"days"`  // "mon".."sun" or full names; empty means every day
+	Start string   `json:"start"` // "HH:MM"
+	End   string   `json:"end"`   // "HH:MM", exclusive; "24:00" is the end of the day
+}
+
+// ScheduleSlot is a window within a single day, in minutes since midnight
+type ScheduleSlot struct {
+	Days  []time.Weekday
+	Start int
+	End   int // exclusive, at most 24:00
+}
+
+// dayInterval is a window starting on a weekday. end is in minutes since
+// midnight of that day and exceeds 24:00 for windows spanning midnight.
+type dayInterval struct {
+	day        time.Weekday
+	start, end int
+}
+
+// Validate checks the time zone, days and times of the schedule
+func (s *Schedule) Validate() error {
+	_, err := s.intervals()
+	return err
+}
+
+// ActiveAt reports whether t falls within one of the schedule's windows
+func (s *Schedule) ActiveAt(t time.Time) (bool, error) {
+	intervals, err := s.intervals()
+	if err != nil {
+		return false, err
+	}
+	loc, err := s.location()
+	if err != nil {
+		return false, err
+	}
+
+	t = t.In(loc)
+	minute := t.Hour()*60 + t.Minute()
+	for _, in := range intervals {
+		if in.day == t.Weekday() && minute >= in.start && minute < in.end {
+			return true, nil
+		}
+		// The part of a window that spans midnight from the previous day
+		if (in.day+1)%7 == t.Weekday() && minute+minutesPerDay < in.end {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Slots converts the schedule into daily slots in loc, using the UTC
+// offsets of the schedule's zone and of loc in effect at t. The slots only
+// change when one of the zones switches to or from daylight saving time, so
+// rules built from them must be regenerated at those moments. Windows that
+// cross midnight in loc are split, and slots with the same times are merged.
+func (s *Schedule) Slots(loc *time.Location, t time.Time) ([]ScheduleSlot, error) {
+	intervals, err := s.intervals()
+	if err != nil {
+		return nil, err
+	}
+	tz, err := s.location()
+	if err != nil {
+		return nil, err
+	}
+
+	const minutesPerWeek = 7 * minutesPerDay
+	_, from := t.In(tz).Zone()
+	_, to := t.In(loc).Zone()
+	shift := (to - from) / 60
+
+	type span struct{ start, end int }
+	days := make(map[span]map[time.Weekday]bool)
+	for _, in := range intervals {
+		// Minutes since Sunday 00:00 in the target zone
+		start := ((int(in.day)*minutesPerDay+in.start+shift)%minutesPerWeek + minutesPerWeek) % minutesPerWeek
+		end := start + in.end - in.start
+
+		for start < end {
+			dayStart := start / minutesPerDay * minutesPerDay
+			segEnd := min(end, dayStart+minutesPerDay)
+
+			key := span{start - dayStart, segEnd - dayStart}
+			if days[key] == nil {
+				days[key] = make(map[time.Weekday]bool)
+			}
+			days[key][time.Weekday(dayStart/minutesPerDay%7)] = true
+			start = segEnd
+		}
+	}
+
+	slots := make([]ScheduleSlot, 0, len(days))
+	for key, set := range days {
+		slot := ScheduleSlot{Start: key.start, End: key.end}
+		for day := time.Sunday; day <= time.Saturday; day++ {
+			if set[day] {
+				slot.Days = append(slot.Days, day)
+			}
+		}
+		slots = append(slots, slot)
+	}
+	sort.Slice(slots, func(i, j int) bool {
+		if slots[i].Start != slots[j].Start {
+			return slots[i].Start < slots[j].Start
+		}
+		return slots[i].End < slots[j].End
+	})
+	return slots, nil
+}
+
+// Key returns a canonical representation of the schedule, e.g. to group
+// networks sharing a schedule
+func (s *Schedule) Key() string {
+	var key strings.Builder
+	key.WriteString(s.Timezone)
+	for _, w := range s.Windows {
+		key.WriteString(fmt.Sprintf("|%s %s-%s", strings.Join(w.Days, ","), w.Start, w.End))
+	}
+	return key.String()
+}
+
+// location returns the schedule's time zone
+func (s *Schedule) location() (*time.Location, error) {
+	if s.Timezone == "" {
+		return time.UTC, nil
+	}
+	loc, err := time.LoadLocation(s.Timezone)
+	if err != nil {
+		return nil, fmt.Errorf("invalid schedule timezone %q: %w", s.Timezone, err)
+	}
+	return loc, nil
+}
+
+// intervals expands the windows into per-weekday intervals in the
+// schedule's time zone
+func (s *Schedule) intervals() ([]dayInterval, error) {
+	if _, err := s.location(); err != nil {
+		return nil, err
+	}
+
+	var intervals []dayInterval
+	for _, w := range s.Windows {
+		start, err := parseClock(w.Start)
+		if err != nil {
+			return nil, err
+		}
+		end, err := parseClock(w.End)
+		if err != nil {
+			return nil, err
+		}
+		days, err := parseDays(w.Days)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, day := range days {
+			switch {
+			case start < end:
+				intervals = append(intervals, dayInterval{day, start, end})
+			case start > end:
+				// Spans midnight into the next day
+				intervals = append(intervals, dayInterval{day, start, minutesPerDay + end})
+			}
+		}
+	}
+	return intervals, nil
+}
+
+// parseClock parses "HH:MM" into minutes since midnight
+func parseClock(s string) (int, error) {
+	var hour, minute int
+	if _, err := fmt.Sscanf(s, "%d:%d", &hour, &minute); err != nil || len(s) != 5 {
+		return 0, fmt.Errorf("invalid schedule time %q", s)
+	}
+	if hour < 0 || minute < 0 || minute > 59 || hour > 24 || (hour == 24 && minute != 0) {
+		return 0, fmt.Errorf("invalid schedule time %q", s)
+	}
+	return hour*60 + minute, nil
+}
+
+// parseDays parses day names; an empty list means every day
+func parseDays(names []string) ([]time.Weekday, error) {
+	if len(names) == 0 {
+		return []time.Weekday{time.Sunday, time.Monday, time.Tuesday, time.Wednesday,
+			time.Thursday, time.Friday, time.Saturday}, nil
+	}
+
+	days := make([]time.Weekday, 0, len(names))
+	for _, name := range names {
+		day, ok := parseDay(name)
+		if !ok {
+			return nil, fmt.Errorf("invalid schedule day %q", name)
+		}
+		days = append(days, day)
+	}
+	return days, nil
+}
+
+// parseDay parses a short ("mon") or full ("Monday") day name
+func parseDay(name string) (time.Weekday, bool) {
+	name = strings.ToLower(name)
+	for day := time.Sunday; day <= time.Saturday; day++ {
+		full := strings.ToLower(day.String())
+		if name == full || name == full[:3] {
+			return day, true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,127 @@
+package api
+
+import (
+	"reflect"
+	"slices"
+	"testing"
+	"time"
+)
+
+func mustLoad(t *testing.T, name string) *time.Location {
+	t.Helper()
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		t.Skipf("time zone %s not available: %v", name, err)
+	}
+	return loc
+}
+
+func TestScheduleSlots(t *testing.T) {
+	prague := mustLoad(t, "Europe/Prague")
+	workday := []ScheduleWindow{{Days: []string{"mon"}, Start: "08:00", End: "17:00"}}
+	winter := time.Date(2026, time.January, 12, 12, 0, 0, 0, time.UTC)
+	summer := time.Date(2026, time.July, 13, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		schedule Schedule
+		loc      *time.Location
+		at       time.Time
+		want     []ScheduleSlot
+	}{
+		{
+			name:     "same zone",
+			schedule: Schedule{Timezone: "Europe/Prague", Windows: workday},
+			loc:      prague,
+			at:       summer,
+			want:     []ScheduleSlot{{Days: []time.Weekday{time.Monday}, Start: 8 * 60, End: 17 * 60}},
+		},
+		{
+			name:     "to UTC in winter",
+			schedule: Schedule{Timezone: "Europe/Prague", Windows: workday},
+			loc:      time.UTC,
+			at:       winter,
+			want:     []ScheduleSlot{{Days: []time.Weekday{time.Monday}, Start: 7 * 60, End: 16 * 60}},
+		},
+		{
+			name:     "to UTC in summer",
+			schedule: Schedule{Timezone: "Europe/Prague", Windows: workday},
+			loc:      time.UTC,
+			at:       summer,
+			want:     []ScheduleSlot{{Days: []time.Weekday{time.Monday}, Start: 6 * 60, End: 15 * 60}},
+		},
+		{
+			name:     "zones switching on different dates",
+			schedule: Schedule{Timezone: "America/New_York", Windows: workday},
+			loc:      prague,
+			at:       time.Date(2026, time.March, 16, 12, 0, 0, 0, time.UTC), // New York on DST, Prague not yet
+			want:     []ScheduleSlot{{Days: []time.Weekday{time.Monday}, Start: 13 * 60, End: 22 * 60}},
+		},
+		{
+			name:     "split at midnight and wrapped around the week",
+			schedule: Schedule{Timezone: "Europe/Prague", Windows: []ScheduleWindow{{Days: []string{"sun"}, Start: "00:30", End: "02:00"}}},
+			loc:      time.UTC,
+			at:       winter,
+			want: []ScheduleSlot{
+				{Days: []time.Weekday{time.Sunday}, Start: 0, End: 60},
+				{Days: []time.Weekday{time.Saturday}, Start: 23*60 + 30, End: 24 * 60},
+			},
+		},
+		{
+			name:     "spanning midnight",
+			schedule: Schedule{Windows: []ScheduleWindow{{Days: []string{"fri"}, Start: "22:00", End: "06:00"}}},
+			loc:      time.UTC,
+			at:       winter,
+			want: []ScheduleSlot{
+				{Days: []time.Weekday{time.Saturday}, Start: 0, End: 6 * 60},
+				{Days: []time.Weekday{time.Friday}, Start: 22 * 60, End: 24 * 60},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.schedule.Slots(tt.loc, tt.at)
+			if err != nil {
+				t.Fatalf("Slots: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Slots = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestScheduleSlotsMatchActiveAt checks that the firewall's slots agree
+// with ActiveAt, which login and connect use, throughout a year
+func TestScheduleSlotsMatchActiveAt(t *testing.T) {
+	loc := mustLoad(t, "Europe/Prague")
+	schedule := Schedule{Timezone: "America/New_York", Windows: []ScheduleWindow{
+		{Days: []string{"mon", "tue"}, Start: "08:00", End: "17:30"},
+		{Days: []string{"fri"}, Start: "22:00", End: "02:00"},
+	}}
+
+	start := time.Date(2026, time.January, 1, 0, 0, 0, 0, time.UTC)
+	for at := start; at.Year() == 2026; at = at.Add(97 * time.Minute) {
+		active, err := schedule.ActiveAt(at)
+		if err != nil {
+			t.Fatal(err)
+		}
+		slots, err := schedule.Slots(loc, at)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		local := at.In(loc)
+		minute := local.Hour()*60 + local.Minute()
+		inSlot := false
+		for _, slot := range slots {
+			if slices.Contains(slot.Days, local.Weekday()) && minute >= slot.Start && minute < slot.End {
+				inSlot = true
+			}
+		}
+		if inSlot != active {
+			t.Fatalf("at %s: in slot = %v, ActiveAt = %v", at, inSlot, active)
+		}
+	}
+}
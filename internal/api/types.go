@@ -21,6 +21,38 @@ type UserResponse struct {
 	ValidFrom *time.Time `json:"valid_from"`
 	ValidTo   *time.Time `json:"valid_to"`
 	VpnIP     string     `json:"vpn_ip"`
+
+	SourceAllow []string `json:"source_allow,omitempty"` // CIDRs the user may connect from
+	SourceDeny  []string `json:"source_deny,omitempty"`  // CIDRs the user may not connect from
+
+	Certificates []Certificate `json:"certificates,omitempty"` // client certificates registered to the user
+}
+
+// Certificate identifies a client certificate registered to a user
+type Certificate struct {
+	Serial      string `json:"serial"`      // hex, ":" separators allowed
+	Fingerprint string `json:"fingerprint"` // SHA-256 hex, ":" separators allowed
+}
+
+// Certificate states reported by the API
+const (
+	CertificateValid   = "valid"
+	CertificateRevoked = "revoked"
+	CertificateUnknown = "unknown"
+)
+
+// CertificateStatus represents the state of a client certificate
+type CertificateStatus struct {
+	Serial      string     `json:"serial"`
+	Fingerprint string     `json:"fingerprint,omitempty"`
+	Username    string     `json:"username"`
+	Status      string     `json:"status"`
+	RevokedAt   *time.Time `json:"revoked_at,omitempty"`
+}
+
+// CertificateListResponse represents the list of known client certificates
+type CertificateListResponse struct {
+	Certificates []CertificateStatus `json:"certificates"`
 }
 
 // UserListResponse represents a paginated list of users
@@ -38,6 +70,9 @@ type GroupWithNetworks struct {
 	Name        string    `json:"name"`
 	Description string    `json:"description"`
 	Networks    []Network `json:"networks"`
+	Schedule    *Schedule `json:"schedule,omitempty"` // applies to networks without their own schedule
+	SourceAllow []string  `json:"source_allow,omitempty"`
+	SourceDeny  []string  `json:"source_deny,omitempty"`
 }
 
 // GroupsResponse represents groups API response
@@ -47,10 +82,11 @@ type GroupsResponse struct {
 
 // Network represents a network/route
 type Network struct {
-	ID          string `json:"id"`
-	Name        string `json:"name"`
-	CIDR        string `json:"cidr"`
-	Description string `json:"description"`
+	ID          string    `json:"id"`
+	Name        string    `json:"name"`
+	CIDR        string    `json:"cidr"`
+	Description string    `json:"description"`
+	Schedule    *Schedule `json:"schedule,omitempty"` // nil means always available
 }
 
 // VpnSession represents a VPN session
@@ -65,10 +101,16 @@ type VpnSession struct {
 	BytesSent      int64      `json:"bytes_sent"`
 }
 
+// SessionsResponse represents a list of VPN sessions
+type SessionsResponse struct {
+	Sessions []VpnSession `json:"sessions"`
+}
+
 // VpnAuthRequest represents VPN authentication request
 type VpnAuthRequest struct {
 	Username string `json:"username"`
 	Password string `json:"password"`
+	OTP      string `json:"otp,omitempty"` // TOTP or one-time code
 }
 
 // VpnAuthResponse represents VPN authentication response
@@ -79,6 +121,34 @@ type VpnAuthResponse struct {
 	StatusCode int          `json:"-"` // HTTP status code (not serialized)
 }
 
+// Web authentication states reported by the API
+const (
+	WebAuthPending  = "pending"
+	WebAuthApproved = "approved"
+	WebAuthDenied   = "denied"
+	WebAuthExpired  = "expired"
+)
+
+// WebAuthRequest represents a request to start a browser login
+type WebAuthRequest struct {
+	Username string `json:"username"`
+	ClientIP string `json:"client_ip,omitempty"`
+}
+
+// WebAuthSession represents a started browser login
+type WebAuthSession struct {
+	ID        string `json:"id"`
+	URL       string `json:"url"`
+	ExpiresIn int    `json:"expires_in,omitempty"` // seconds
+}
+
+// WebAuthStatus represents the state of a browser login
+type WebAuthStatus struct {
+	Status  string       `json:"status"`
+	User    UserResponse `json:"user"` // set once approved
+	Message string       `json:"message,omitempty"`
+}
+
 // CreateSessionRequest represents request to create VPN session
 type CreateSessionRequest struct {
 	UserID      string `json:"user_id"`
@@ -110,3 +180,24 @@ type VpnUsersResponse struct {
 type RoutesResponse struct {
 	Routes []Network `json:"routes"`
 }
+
+// UserWithRoutes represents a user together with its routes (bulk endpoint)
+type UserWithRoutes struct {
+	UserResponse
+	Routes []Network `json:"routes"`
+}
+
+// CapabilitiesResponse represents the features advertised by the API
+type CapabilitiesResponse struct {
+	Features []string `json:"features"`
+}
+
+// Has reports whether the API advertises the given feature
+func (c *CapabilitiesResponse) Has(feature string) bool {
+	for _, f := range c.Features {
+		if f == feature {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,41 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	// ErrNotYetValid is returned for users before their ValidFrom time
+	ErrNotYetValid = errors.New("user is not valid yet")
+	// ErrExpired is returned for users after their ValidTo time
+	ErrExpired = errors.New("user has expired")
+)
+
+// CheckValidity returns an error if t is outside the validity window
+// [from, to). A nil bound is open. skew widens the window on both sides to
+// tolerate clock differences between this host and the API server.
+func CheckValidity(from, to *time.Time, t time.Time, skew time.Duration) error {
+	if from != nil && t.Add(skew).Before(*from) {
+		return fmt.Errorf("%w (valid from %s)", ErrNotYetValid, from.Format(time.RFC3339))
+	}
+	if to != nil && !t.Add(-skew).Before(*to) {
+		return fmt.Errorf("%w (valid to %s)", ErrExpired, to.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// CheckValidity returns an error if the user is outside their validity window at t
+func (u *UserResponse) CheckValidity(t time.Time, skew time.Duration) error {
+	return CheckValidity(u.ValidFrom, u.ValidTo, t, skew)
+}
+
+// RemainingValidity returns the time left until ValidTo, or false if the
+// user has no end date
+func (u *UserResponse) RemainingValidity(t time.Time) (time.Duration, bool) {
+	if u.ValidTo == nil {
+		return 0, false
+	}
+	return u.ValidTo.Sub(t), true
+}
@@ -0,0 +1,110 @@
+package authtoken
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// prefix marks tokens issued by openvpn-connect
+const prefix = "OVT1:"
+
+// minSecretSize is the minimum size of the HMAC secret
+const minSecretSize = 32
+
+var (
+	// ErrInvalid is returned for malformed, forged or foreign tokens
+	ErrInvalid = errors.New("invalid auth token")
+	// ErrExpired is returned for tokens past their expiry
+	ErrExpired = errors.New("auth token expired")
+)
+
+// Signer issues and verifies HMAC-SHA256 signed session tokens. A token
+// binds a username to an expiry time and is pushed to the client with
+// "auth-token", which then sends it as password on renegotiation.
+type Signer struct {
+	secret []byte
+}
+
+// NewSigner creates a signer with the given secret
+func NewSigner(secret []byte) (*Signer, error) {
+	if len(secret) < minSecretSize {
+		return nil, fmt.Errorf("auth token secret must be at least %d bytes", minSecretSize)
+	}
+	return &Signer{secret: secret}, nil
+}
+
+// LoadSigner creates a signer with the secret stored in path
+func LoadSigner(path string) (*Signer, error) {
+	secret, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read auth token secret: %w", err)
+	}
+	return NewSigner(secret)
+}
+
+// IsToken reports whether the password has the format of a token issued by
+// a Signer. A user's password may have it too, so only Verify tells tokens
+// apart.
+func IsToken(password string) bool {
+	return strings.HasPrefix(password, prefix)
+}
+
+// Issue returns a token for username that is valid until expires
+func (s *Signer) Issue(username string, expires time.Time) (string, error) {
+	nonce := make([]byte, 12)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	payload := strings.Join([]string{
+		username,
+		strconv.FormatInt(expires.Unix(), 10),
+		hex.EncodeToString(nonce),
+	}, "\x00")
+	encoded := base64.RawURLEncoding.EncodeToString([]byte(payload))
+	return prefix + encoded + "." + s.sign(encoded), nil
+}
+
+// Verify checks the signature, username and expiry of a token
+func (s *Signer) Verify(token, username string, now time.Time) error {
+	body, ok := strings.CutPrefix(token, prefix)
+	if !ok {
+		return ErrInvalid
+	}
+	encoded, mac, ok := strings.Cut(body, ".")
+	if !ok || !hmac.Equal([]byte(mac), []byte(s.sign(encoded))) {
+		return ErrInvalid
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return ErrInvalid
+	}
+	fields := strings.Split(string(payload), "\x00")
+	if len(fields) != 3 || fields[0] != username {
+		return ErrInvalid
+	}
+	expires, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return ErrInvalid
+	}
+	if now.Unix() >= expires {
+		return ErrExpired
+	}
+	return nil
+}
+
+func (s *Signer) sign(encoded string) string {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(prefix + encoded))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
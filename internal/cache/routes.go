@@ -0,0 +1,67 @@
+package cache
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/tldr-it-stepankutaj/openvpn-client/internal/api"
+)
+
+const routesPrefix = "routes-"
+
+// RouteCache stores users' routes on disk so openvpn-connect can configure
+// clients when the API does not answer in time
+type RouteCache struct {
+	dir string
+	ttl time.Duration
+}
+
+type routesEntry struct {
+	CachedAt time.Time     `json:"cached_at"`
+	Routes   []api.Network `json:"routes"`
+}
+
+// NewRouteCache creates a new route cache in dir with the given TTL
+func NewRouteCache(dir string, ttl time.Duration) *RouteCache {
+	return &RouteCache{dir: dir, ttl: ttl}
+}
+
+// Get returns the cached routes of a user if present and not older than
+// the TTL
+func (c *RouteCache) Get(username string) ([]api.Network, bool) {
+	data, err := os.ReadFile(c.path(username))
+	if err != nil {
+		return nil, false
+	}
+
+	var entry routesEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return nil, false
+	}
+
+	if c.ttl > 0 && time.Since(entry.CachedAt) > c.ttl {
+		return nil, false
+	}
+
+	return entry.Routes, true
+}
+
+// Put stores the routes of a user in the cache
+func (c *RouteCache) Put(username string, routes []api.Network) error {
+	data, err := json.Marshal(routesEntry{
+		CachedAt: time.Now().UTC(),
+		Routes:   routes,
+	})
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(c.path(username), data, 0600)
+}
+
+func (c *RouteCache) path(username string) string {
+	key := strings.NewReplacer("/", "_", "\\", "_", "\x00", "_").Replace(username)
+	return filepath.Join(c.dir, routesPrefix+key+".json")
+}
@@ -0,0 +1,66 @@
+package cache
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/tldr-it-stepankutaj/openvpn-client/internal/api"
+)
+
+const userPrefix = "user-"
+
+// UserCache stores API user records on disk so hooks can resolve users
+// without an API round trip
+type UserCache struct {
+	dir string
+	ttl time.Duration
+}
+
+type userEntry struct {
+	CachedAt time.Time        `json:"cached_at"`
+	User     api.UserResponse `json:"user"`
+}
+
+// NewUserCache creates a new user cache in dir with the given TTL
+func NewUserCache(dir string, ttl time.Duration) *UserCache {
+	return &UserCache{dir: dir, ttl: ttl}
+}
+
+// Get returns the cached user if present and not older than the TTL
+func (c *UserCache) Get(username string) (*api.UserResponse, bool) {
+	data, err := os.ReadFile(c.path(username))
+	if err != nil {
+		return nil, false
+	}
+
+	var entry userEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return nil, false
+	}
+
+	if c.ttl > 0 && time.Since(entry.CachedAt) > c.ttl {
+		return nil, false
+	}
+
+	return &entry.User, true
+}
+
+// Put stores the user in the cache
+func (c *UserCache) Put(user *api.UserResponse) error {
+	data, err := json.Marshal(userEntry{
+		CachedAt: time.Now().UTC(),
+		User:     *user,
+	})
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(c.path(user.Username), data, 0600)
+}
+
+func (c *UserCache) path(username string) string {
+	key := strings.NewReplacer("/", "_", "\\", "_", "\x00", "_").Replace(username)
+	return filepath.Join(c.dir, userPrefix+key+".json")
+}
@@ -3,6 +3,8 @@ package config
 import (
 	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
 	"time"
 
 	"gopkg.in/yaml.v3"
@@ -13,6 +15,52 @@ const (
 	DefaultTimeout    = 10 * time.Second
 	DefaultSessionDir = "/var/run/openvpn"
 	DefaultFirewall   = "nftables"
+	DefaultRulesMode  = "user"
+	DefaultCacheTTL   = 1 * time.Hour
+	DefaultClockSkew  = 1 * time.Minute
+
+	DefaultLoginLimitWindow  = 10 * time.Minute
+	DefaultLoginLimitLockout = 15 * time.Minute
+	DefaultLoginLimitFile    = "login-limit.json"
+	DefaultCertListFile      = "certificates.json"
+
+	DefaultDeferredAuthTimeout     = 30 * time.Second
+	DefaultDeferredConnectTimeout  = 10 * time.Second
+	DefaultDeferredConnectFallback = "deny"
+
+	DefaultAuthTokenLifetime = 12 * time.Hour
+
+	DefaultWebAuthTimeout      = 5 * time.Minute
+	DefaultWebAuthPollInterval = 2 * time.Second
+
+	DefaultCertBindingPolicy = "ignore"
+	DefaultCertStatusMode    = "off"
+	DefaultCertListMaxAge    = 15 * time.Minute
+
+	DefaultMFAChallenge     = "static"
+	DefaultMFAChallengeText = "Enter your one-time code"
+	DefaultMFAChallengeTTL  = 2 * time.Minute
+
+	DefaultSessionLimitPolicy = "reject"
+	DefaultManagementTimeout  = 5 * time.Second
+
+	DefaultMaxRemovedPercent = 50
+	DefaultConcurrency       = 8
+
+	DefaultPKIDir       = "/etc/openvpn/pki"
+	DefaultCRLValidity  = 30 * 24 * time.Hour
+	DefaultServerCN     = "server"
+	DefaultKeyType      = "ecdsa"
+	DefaultCertValidity = 365 * 24 * time.Hour
+	DefaultRenewBefore  = 30 * 24 * time.Hour
+
+	DefaultProfileProto   = "udp"
+	DefaultProfileUDPPort = 1194
+	DefaultProfileTCPPort = 993
+
+	DefaultDaemonInterval   = 5 * time.Minute
+	DefaultDaemonJitter     = 30 * time.Second
+	DefaultDaemonMaxBackoff = 10 * time.Minute
 
 	EnvConfigPath   = "OPENVPN_CLIENT_CONFIG"
 	EnvAPIBaseURL   = "OPENVPN_API_BASE_URL"
@@ -28,6 +76,8 @@ type Config struct {
 	API      APIConfig      `yaml:"api"`
 	OpenVPN  OpenVPNConfig  `yaml:"openvpn"`
 	Firewall FirewallConfig `yaml:"firewall"`
+	PKI      PKIConfig      `yaml:"pki"`
+	Profile  ProfileConfig  `yaml:"profile"`
 }
 
 type APIConfig struct {
@@ -39,24 +89,187 @@ type APIConfig struct {
 }
 
 type OpenVPNConfig struct {
-	SessionDir string `yaml:"session_dir"`
+	SessionDir   string         `yaml:"session_dir"`
+	UserCacheTTL time.Duration  `yaml:"user_cache_ttl"`
+	ClockSkew    *time.Duration `yaml:"clock_skew"` // tolerance for valid_from/valid_to checks; 0 disables it
+
+	SessionLimit    SessionLimitConfig    `yaml:"session_limit"`
+	Management      ManagementConfig      `yaml:"management"`
+	SourceFilter    SourceFilterConfig    `yaml:"source_filter"`
+	LoginLimit      LoginLimitConfig      `yaml:"login_limit"`
+	MFA             MFAConfig             `yaml:"mfa"`
+	DeferredAuth    DeferredAuthConfig    `yaml:"deferred_auth"`
+	DeferredConnect DeferredConnectConfig `yaml:"deferred_connect"`
+	AuthToken       AuthTokenConfig       `yaml:"auth_token"`
+	WebAuth         WebAuthConfig         `yaml:"web_auth"`
+	CertBinding     CertBindingConfig     `yaml:"cert_binding"`
+	CertStatus      CertStatusConfig      `yaml:"cert_status"`
+}
+
+// CertStatusConfig configures certificate status and CA checks in
+// openvpn-tls-verify
+type CertStatusConfig struct {
+	Mode      string        `yaml:"mode"`       // "off", "api" (lookup per handshake) or "list" (local list synced from the API)
+	ListFile  string        `yaml:"list_file"`  // default: <session_dir>/certificates.json
+	MaxAge    time.Duration `yaml:"max_age"`    // refresh the list when older (list mode)
+	PinnedCAs []string      `yaml:"pinned_cas"` // SHA-256 fingerprints of accepted CA certificates, empty = any
+}
+
+// CertBindingConfig binds client certificates to API users in
+// openvpn-login and openvpn-tls-verify
+type CertBindingConfig struct {
+	Policy string `yaml:"policy"` // "ignore", "match" (CN = username) or "owned" (registered to the user)
+}
+
+// WebAuthConfig configures browser single sign-on in openvpn-login through
+// OpenVPN's pending authentication (WEB_AUTH, OpenVPN 2.6+)
+type WebAuthConfig struct {
+	Enabled      bool          `yaml:"enabled"`
+	Required     bool          `yaml:"required"`      // reject clients without web authentication support
+	Timeout      time.Duration `yaml:"timeout"`       // time to finish the browser login
+	PollInterval time.Duration `yaml:"poll_interval"` // how often the API is asked for the result
+}
+
+// AuthTokenConfig configures session tokens pushed by openvpn-connect and
+// accepted by openvpn-login on renegotiation instead of the password
+type AuthTokenConfig struct {
+	Enabled    bool          `yaml:"enabled"`
+	SecretFile string        `yaml:"secret_file"` // HMAC secret, at least 32 bytes, shared by all servers
+	Lifetime   time.Duration `yaml:"lifetime"`    // capped at the user's valid_to
+}
+
+// DeferredAuthConfig configures deferred authentication in openvpn-login:
+// the hook returns immediately and a background worker writes the result to
+// auth_control_file (OpenVPN 2.6+)
+type DeferredAuthConfig struct {
+	Enabled       bool          `yaml:"enabled"`
+	Timeout       time.Duration `yaml:"timeout"`        // deny if the worker has no result by then
+	PendingMethod string        `yaml:"pending_method"` // auth_pending_file method (e.g. "crtext"), empty = none
+	PendingExtra  string        `yaml:"pending_extra"`  // auth_pending_file extra line for the method
+}
+
+// DeferredConnectConfig configures deferred client-connect in
+// openvpn-connect: a background worker writes the client config and the
+// result to client_connect_deferred_file (OpenVPN 2.5+)
+type DeferredConnectConfig struct {
+	Enabled  bool          `yaml:"enabled"`
+	Timeout  time.Duration `yaml:"timeout"`  // deadline for the API calls
+	Fallback string        `yaml:"fallback"` // "deny" or "cache" when the deadline passes
+}
+
+// MFAConfig configures one-time codes in openvpn-login. Static (SCRV1) and
+// dynamic (CRV1) challenge responses are always accepted.
+type MFAConfig struct {
+	Required      bool          `yaml:"required"`       // reject logins without a one-time code
+	Challenge     string        `yaml:"challenge"`      // "static" (client static-challenge) or "dynamic" (CRV1)
+	ChallengeText string        `yaml:"challenge_text"` // prompt shown for dynamic challenges
+	ChallengeTTL  time.Duration `yaml:"challenge_ttl"`  // how long a dynamic challenge can be answered
+}
+
+// LoginLimitConfig configures the local limiter of failed logins.
+// A zero threshold disables limiting by that key.
+type LoginLimitConfig struct {
+	MaxFailuresPerIP   int           `yaml:"max_failures_per_ip"`
+	MaxFailuresPerUser int           `yaml:"max_failures_per_user"`
+	Window             time.Duration `yaml:"window"`     // sliding window for counting failures
+	Lockout            time.Duration `yaml:"lockout"`    // how long an IP or username stays locked out
+	StateFile          string        `yaml:"state_file"` // default: <session_dir>/login-limit.json
+	BanSet             string        `yaml:"ban_set"`    // nftables set for locked out IPs: "<family> <table> <set>"
+}
+
+// SourceFilterConfig restricts where users may connect from
+type SourceFilterConfig struct {
+	BlocklistFile string `yaml:"blocklist_file"` // local IPs/CIDRs denied for everyone
+	GroupRules    bool   `yaml:"group_rules"`    // also apply the allow/deny lists of the user's groups
+}
+
+// SessionLimitConfig limits concurrent sessions per user
+type SessionLimitConfig struct {
+	MaxSessions int            `yaml:"max_sessions"` // 0 = unlimited
+	Roles       map[string]int `yaml:"roles"`        // per-role limits overriding max_sessions
+	Policy      string         `yaml:"policy"`       // "reject" (new session) or "kill_oldest"
+}
+
+// ManagementConfig configures access to the OpenVPN management interface
+type ManagementConfig struct {
+	Address  string        `yaml:"address"` // "host:port" or "unix:/path/to/socket"
+	Password string        `yaml:"password"`
+	Timeout  time.Duration `yaml:"timeout"`
+}
+
+// PKIConfig locates the local CA used by openvpn-crl and openvpn-pki. Paths
+// default to the easy-rsa layout under dir.
+type PKIConfig struct {
+	Dir           string        `yaml:"dir"`
+	CACert        string        `yaml:"ca_cert"`        // default: <dir>/ca.crt
+	CAKey         string        `yaml:"ca_key"`         // default: <dir>/private/ca.key, unencrypted
+	IndexFile     string        `yaml:"index_file"`     // default: <dir>/index.txt
+	CRLFile       string        `yaml:"crl_file"`       // default: <dir>/crl.pem, the crl-verify file
+	CRLValidity   time.Duration `yaml:"crl_validity"`   // nextUpdate of generated CRLs
+	RevokeUnknown bool          `yaml:"revoke_unknown"` // also revoke certificates of users the API does not know
+	ExcludeCNs    []string      `yaml:"exclude_cns"`    // never revoked, e.g. server certificates (default: server)
+
+	KeyType      string        `yaml:"key_type"`      // keys of issued client certificates: "ecdsa" (P-256) or "ed25519"
+	CertValidity time.Duration `yaml:"cert_validity"` // lifetime of issued client certificates
+	RenewBefore  time.Duration `yaml:"renew_before"`  // renew certificates expiring within this time
+}
+
+// ProfileConfig configures client profiles (.ovpn) rendered by
+// openvpn-profile
+type ProfileConfig struct {
+	Remote       string `yaml:"remote"`         // server host name or address
+	Proto        string `yaml:"proto"`          // default variant: "udp" or "tcp"
+	UDPPort      int    `yaml:"udp_port"`       // port of the UDP variant
+	TCPPort      int    `yaml:"tcp_port"`       // port of the TCP variant
+	CAFile       string `yaml:"ca_file"`        // default: pki.ca_cert
+	TLSAuthFile  string `yaml:"tls_auth_file"`  // inlined as <tls-auth>, empty = none
+	TLSCryptFile string `yaml:"tls_crypt_file"` // inlined as <tls-crypt> instead of tls-auth
+	ClientCerts  bool   `yaml:"client_certs"`   // inline <pki.dir>/issued/<user>.crt and private/<user>.key
+	Template     string `yaml:"template"`       // text/template file, empty = built-in
+	OutputDir    string `yaml:"output_dir"`     // batch mode output, default: current directory
 }
 
 type FirewallConfig struct {
 	Type     string         `yaml:"type"`
+	Mode     string         `yaml:"mode"` // "user" (rules per user) or "group" (rules per group)
 	NFTables NFTablesConfig `yaml:"nftables"`
 	IPTables IPTablesConfig `yaml:"iptables"`
+	Safety   SafetyConfig   `yaml:"safety"`
+	Daemon   DaemonConfig   `yaml:"daemon"`
+
+	Concurrency    int           `yaml:"concurrency"`     // parallel route requests
+	RequestTimeout time.Duration `yaml:"request_timeout"` // timeout per route request
+}
+
+// SafetyConfig limits how much of the current ruleset a single run may remove.
+// A zero count or percentage disables that check; the percentage applies to
+// both users and rules and defaults to DefaultMaxRemovedPercent when unset.
+type SafetyConfig struct {
+	MaxRemovedUsers   int      `yaml:"max_removed_users"`
+	MaxRemovedRules   int      `yaml:"max_removed_rules"`
+	MaxRemovedPercent *float64 `yaml:"max_removed_percent"`
+	AllowPartial      bool     `yaml:"allow_partial"` // apply even if some users' routes could not be fetched
+}
+
+// DaemonConfig configures openvpn-firewall --daemon
+type DaemonConfig struct {
+	Interval   time.Duration `yaml:"interval"`
+	Jitter     time.Duration `yaml:"jitter"`
+	MaxBackoff time.Duration `yaml:"max_backoff"`
 }
 
 type NFTablesConfig struct {
 	RulesFile     string `yaml:"rules_file"`
 	ReloadCommand string `yaml:"reload_command"`
+	DynamicSet    string `yaml:"dynamic_set"` // "<family> <table> <set>", e.g. "inet filter vpn_active"
+	TimeBounds    bool   `yaml:"time_bounds"` // limit user rules to valid_from/valid_to with "meta time"
 }
 
 type IPTablesConfig struct {
 	ChainName     string `yaml:"chain_name"`
 	RulesFile     string `yaml:"rules_file"`
 	ReloadCommand string `yaml:"reload_command"`
+	IPSet         string `yaml:"ipset"` // ipset name for active VPN addresses
 }
 
 // UseToken returns true if API token authentication should be used
@@ -64,6 +277,22 @@ func (c *APIConfig) UseToken() bool {
 	return c.Token != ""
 }
 
+// Skew returns the clock skew tolerated in validity checks (0 if unset)
+func (c *OpenVPNConfig) Skew() time.Duration {
+	if c.ClockSkew == nil {
+		return 0
+	}
+	return *c.ClockSkew
+}
+
+// Limit returns the maximum number of concurrent sessions for a role (0 = unlimited)
+func (c *SessionLimitConfig) Limit(role string) int {
+	if limit, ok := c.Roles[role]; ok {
+		return limit
+	}
+	return c.MaxSessions
+}
+
 // Load loads configuration from file with environment variable overrides.
 // Priority: CLI argument > environment variable > config file > defaults
 func Load(configPath string) (*Config, error) {
@@ -147,9 +376,131 @@ func applyDefaults(cfg *Config) {
 	if cfg.OpenVPN.SessionDir == "" {
 		cfg.OpenVPN.SessionDir = DefaultSessionDir
 	}
+	if cfg.OpenVPN.UserCacheTTL == 0 {
+		cfg.OpenVPN.UserCacheTTL = DefaultCacheTTL
+	}
+	if cfg.OpenVPN.ClockSkew == nil {
+		skew := DefaultClockSkew
+		cfg.OpenVPN.ClockSkew = &skew
+	}
+	if cfg.OpenVPN.LoginLimit.Window == 0 {
+		cfg.OpenVPN.LoginLimit.Window = DefaultLoginLimitWindow
+	}
+	if cfg.OpenVPN.LoginLimit.Lockout == 0 {
+		cfg.OpenVPN.LoginLimit.Lockout = DefaultLoginLimitLockout
+	}
+	if cfg.OpenVPN.LoginLimit.StateFile == "" {
+		cfg.OpenVPN.LoginLimit.StateFile = filepath.Join(cfg.OpenVPN.SessionDir, DefaultLoginLimitFile)
+	}
+	if cfg.OpenVPN.DeferredAuth.Timeout == 0 {
+		cfg.OpenVPN.DeferredAuth.Timeout = DefaultDeferredAuthTimeout
+	}
+	if cfg.OpenVPN.DeferredConnect.Timeout == 0 {
+		cfg.OpenVPN.DeferredConnect.Timeout = DefaultDeferredConnectTimeout
+	}
+	if cfg.OpenVPN.DeferredConnect.Fallback == "" {
+		cfg.OpenVPN.DeferredConnect.Fallback = DefaultDeferredConnectFallback
+	}
+	if cfg.OpenVPN.AuthToken.Lifetime == 0 {
+		cfg.OpenVPN.AuthToken.Lifetime = DefaultAuthTokenLifetime
+	}
+	if cfg.OpenVPN.WebAuth.Timeout == 0 {
+		cfg.OpenVPN.WebAuth.Timeout = DefaultWebAuthTimeout
+	}
+	if cfg.OpenVPN.WebAuth.PollInterval == 0 {
+		cfg.OpenVPN.WebAuth.PollInterval = DefaultWebAuthPollInterval
+	}
+	if cfg.OpenVPN.CertBinding.Policy == "" {
+		cfg.OpenVPN.CertBinding.Policy = DefaultCertBindingPolicy
+	}
+	if cfg.OpenVPN.CertStatus.Mode == "" {
+		cfg.OpenVPN.CertStatus.Mode = DefaultCertStatusMode
+	}
+	if cfg.OpenVPN.CertStatus.ListFile == "" {
+		cfg.OpenVPN.CertStatus.ListFile = filepath.Join(cfg.OpenVPN.SessionDir, DefaultCertListFile)
+	}
+	if cfg.OpenVPN.CertStatus.MaxAge == 0 {
+		cfg.OpenVPN.CertStatus.MaxAge = DefaultCertListMaxAge
+	}
+	if cfg.OpenVPN.MFA.Challenge == "" {
+		cfg.OpenVPN.MFA.Challenge = DefaultMFAChallenge
+	}
+	if cfg.OpenVPN.MFA.ChallengeText == "" {
+		cfg.OpenVPN.MFA.ChallengeText = DefaultMFAChallengeText
+	}
+	if cfg.OpenVPN.MFA.ChallengeTTL == 0 {
+		cfg.OpenVPN.MFA.ChallengeTTL = DefaultMFAChallengeTTL
+	}
+	if cfg.OpenVPN.SessionLimit.Policy == "" {
+		cfg.OpenVPN.SessionLimit.Policy = DefaultSessionLimitPolicy
+	}
+	if cfg.OpenVPN.Management.Timeout == 0 {
+		cfg.OpenVPN.Management.Timeout = DefaultManagementTimeout
+	}
+	if cfg.PKI.Dir == "" {
+		cfg.PKI.Dir = DefaultPKIDir
+	}
+	if cfg.PKI.CACert == "" {
+		cfg.PKI.CACert = filepath.Join(cfg.PKI.Dir, "ca.crt")
+	}
+	if cfg.PKI.CAKey == "" {
+		cfg.PKI.CAKey = filepath.Join(cfg.PKI.Dir, "private", "ca.key")
+	}
+	if cfg.PKI.IndexFile == "" {
+		cfg.PKI.IndexFile = filepath.Join(cfg.PKI.Dir, "index.txt")
+	}
+	if cfg.PKI.CRLFile == "" {
+		cfg.PKI.CRLFile = filepath.Join(cfg.PKI.Dir, "crl.pem")
+	}
+	if cfg.PKI.CRLValidity == 0 {
+		cfg.PKI.CRLValidity = DefaultCRLValidity
+	}
+	if cfg.PKI.ExcludeCNs == nil {
+		cfg.PKI.ExcludeCNs = []string{DefaultServerCN}
+	}
+	if cfg.PKI.KeyType == "" {
+		cfg.PKI.KeyType = DefaultKeyType
+	}
+	if cfg.PKI.CertValidity == 0 {
+		cfg.PKI.CertValidity = DefaultCertValidity
+	}
+	if cfg.PKI.RenewBefore == 0 {
+		cfg.PKI.RenewBefore = DefaultRenewBefore
+	}
+	if cfg.Profile.Proto == "" {
+		cfg.Profile.Proto = DefaultProfileProto
+	}
+	if cfg.Profile.UDPPort == 0 {
+		cfg.Profile.UDPPort = DefaultProfileUDPPort
+	}
+	if cfg.Profile.TCPPort == 0 {
+		cfg.Profile.TCPPort = DefaultProfileTCPPort
+	}
+	if cfg.Profile.CAFile == "" {
+		cfg.Profile.CAFile = cfg.PKI.CACert
+	}
 	if cfg.Firewall.Type == "" {
 		cfg.Firewall.Type = DefaultFirewall
 	}
+	if cfg.Firewall.Mode == "" {
+		cfg.Firewall.Mode = DefaultRulesMode
+	}
+	if cfg.Firewall.Concurrency == 0 {
+		cfg.Firewall.Concurrency = DefaultConcurrency
+	}
+	if cfg.Firewall.Safety.MaxRemovedPercent == nil {
+		pct := float64(DefaultMaxRemovedPercent)
+		cfg.Firewall.Safety.MaxRemovedPercent = &pct
+	}
+	if cfg.Firewall.Daemon.Interval == 0 {
+		cfg.Firewall.Daemon.Interval = DefaultDaemonInterval
+	}
+	if cfg.Firewall.Daemon.Jitter == 0 {
+		cfg.Firewall.Daemon.Jitter = DefaultDaemonJitter
+	}
+	if cfg.Firewall.Daemon.MaxBackoff == 0 {
+		cfg.Firewall.Daemon.MaxBackoff = DefaultDaemonMaxBackoff
+	}
 }
 
 // Validate checks if the configuration is valid
@@ -167,5 +518,108 @@ func (c *Config) Validate() error {
 		return fmt.Errorf("firewall.type must be 'nftables' or 'iptables'")
 	}
 
+	if c.Firewall.Mode != "user" && c.Firewall.Mode != "group" {
+		return fmt.Errorf("firewall.mode must be 'user' or 'group'")
+	}
+
+	if s := c.OpenVPN.ClockSkew; s != nil && *s < 0 {
+		return fmt.Errorf("openvpn.clock_skew must not be negative")
+	}
+
+	if set := c.OpenVPN.LoginLimit.BanSet; set != "" && len(strings.Fields(set)) != 3 {
+		return fmt.Errorf("openvpn.login_limit.ban_set must be '<family> <table> <set>'")
+	}
+
+	if c.OpenVPN.DeferredAuth.Timeout < time.Second {
+		return fmt.Errorf("openvpn.deferred_auth.timeout must be at least 1s")
+	}
+
+	if c.OpenVPN.DeferredConnect.Timeout < time.Second {
+		return fmt.Errorf("openvpn.deferred_connect.timeout must be at least 1s")
+	}
+	if f := c.OpenVPN.DeferredConnect.Fallback; f != "deny" && f != "cache" {
+		return fmt.Errorf("openvpn.deferred_connect.fallback must be 'deny' or 'cache'")
+	}
+
+	if c.OpenVPN.AuthToken.Enabled && c.OpenVPN.AuthToken.SecretFile == "" {
+		return fmt.Errorf("openvpn.auth_token.secret_file is required when auth tokens are enabled")
+	}
+
+	if c.OpenVPN.WebAuth.Enabled {
+		if !c.API.UseToken() {
+			return fmt.Errorf("openvpn.web_auth requires api.token")
+		}
+		if c.OpenVPN.WebAuth.Timeout < time.Second || c.OpenVPN.WebAuth.PollInterval <= 0 {
+			return fmt.Errorf("openvpn.web_auth.timeout must be at least 1s and poll_interval positive")
+		}
+	}
+
+	if c.OpenVPN.MFA.Required && !c.API.UseToken() {
+		return fmt.Errorf("openvpn.mfa.required requires api.token")
+	}
+
+	switch c.OpenVPN.CertBinding.Policy {
+	case "ignore", "match", "owned":
+	default:
+		return fmt.Errorf("openvpn.cert_binding.policy must be 'ignore', 'match' or 'owned'")
+	}
+
+	switch c.OpenVPN.CertStatus.Mode {
+	case "off", "api", "list":
+	default:
+		return fmt.Errorf("openvpn.cert_status.mode must be 'off', 'api' or 'list'")
+	}
+
+	if m := c.OpenVPN.MFA.Challenge; m != "static" && m != "dynamic" {
+		return fmt.Errorf("openvpn.mfa.challenge must be 'static' or 'dynamic'")
+	}
+
+	limit := c.OpenVPN.SessionLimit
+	if limit.Policy != "reject" && limit.Policy != "kill_oldest" {
+		return fmt.Errorf("openvpn.session_limit.policy must be 'reject' or 'kill_oldest'")
+	}
+	if limit.Policy == "kill_oldest" && c.OpenVPN.Management.Address == "" {
+		return fmt.Errorf("openvpn.session_limit.policy 'kill_oldest' requires openvpn.management.address")
+	}
+
+	if c.PKI.CRLValidity < time.Hour {
+		return fmt.Errorf("pki.crl_validity must be at least 1h")
+	}
+	if k := c.PKI.KeyType; k != "ecdsa" && k != "ed25519" {
+		return fmt.Errorf("pki.key_type must be 'ecdsa' or 'ed25519'")
+	}
+	if c.PKI.CertValidity < 24*time.Hour {
+		return fmt.Errorf("pki.cert_validity must be at least 24h")
+	}
+	if c.PKI.RenewBefore < 0 || c.PKI.RenewBefore >= c.PKI.CertValidity {
+		return fmt.Errorf("pki.renew_before must be between 0 and pki.cert_validity")
+	}
+
+	if p := c.Profile.Proto; p != "udp" && p != "tcp" {
+		return fmt.Errorf("profile.proto must be 'udp' or 'tcp'")
+	}
+	if !validPort(c.Profile.UDPPort) || !validPort(c.Profile.TCPPort) {
+		return fmt.Errorf("profile.udp_port and profile.tcp_port must be between 1 and 65535")
+	}
+	if c.Profile.TLSAuthFile != "" && c.Profile.TLSCryptFile != "" {
+		return fmt.Errorf("profile.tls_auth_file and profile.tls_crypt_file are mutually exclusive")
+	}
+
+	if c.Firewall.Concurrency < 1 {
+		return fmt.Errorf("firewall.concurrency must be at least 1")
+	}
+
+	if p := c.Firewall.Safety.MaxRemovedPercent; p != nil && (*p < 0 || *p > 100) {
+		return fmt.Errorf("firewall.safety.max_removed_percent must be between 0 and 100")
+	}
+
+	if set := c.Firewall.NFTables.DynamicSet; set != "" && len(strings.Fields(set)) != 3 {
+		return fmt.Errorf("firewall.nftables.dynamic_set must be '<family> <table> <set>'")
+	}
+
 	return nil
 }
+
+func validPort(port int) bool {
+	return port > 0 && port <= 65535
+}
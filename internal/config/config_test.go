@@ -0,0 +1,63 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadClockSkew(t *testing.T) {
+	tests := []struct {
+		name string
+		yaml string
+		want time.Duration
+	}{
+		{"default", "", DefaultClockSkew},
+		{"explicit", "openvpn:\n  clock_skew: 5m\n", 5 * time.Minute},
+		{"disabled", "openvpn:\n  clock_skew: 0s\n", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "config.yaml")
+			data := "api:\n  base_url: https://vpn.example\n  token: test\n" + tt.yaml
+			if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			cfg, err := Load(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := cfg.OpenVPN.Skew(); got != tt.want {
+				t.Errorf("clock skew = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadNegativeClockSkew(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	data := "api:\n  base_url: https://vpn.example\n  token: test\nopenvpn:\n  clock_skew: -1m\n"
+	if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Load(path); err == nil || !strings.Contains(err.Error(), "openvpn.clock_skew") {
+		t.Errorf("Load = %v, want an openvpn.clock_skew error", err)
+	}
+}
+
+func TestLoadMFARequiresToken(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	data := "api:\n  base_url: https://vpn.example\n  username: svc\n  password: secret\nopenvpn:\n  mfa:\n    required: true\n"
+	if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Load(path); err == nil || !strings.Contains(err.Error(), "openvpn.mfa.required") {
+		t.Errorf("Load = %v, want an openvpn.mfa.required error", err)
+	}
+}
@@ -0,0 +1,150 @@
+package deferred
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/tldr-it-stepankutaj/openvpn-client/internal/utils"
+)
+
+// ExitDeferred tells OpenVPN that the result will be written later
+const ExitDeferred = 2
+
+// ErrFinished is returned when a result has already been written
+var ErrFinished = errors.New("deferred result already written")
+
+// Auth reports the result of a deferred authentication through
+// auth_control_file (OpenVPN 2.6+)
+type Auth struct {
+	controlFile string
+	pendingFile string
+
+	once sync.Once
+}
+
+// AuthFromEnv returns the deferred authentication files provided by
+// OpenVPN, or nil when auth_control_file is not set
+func AuthFromEnv() *Auth {
+	control := os.Getenv("auth_control_file")
+	if control == "" {
+		return nil
+	}
+	return &Auth{
+		controlFile: control,
+		pendingFile: os.Getenv("auth_pending_file"),
+	}
+}
+
+// Pending asks OpenVPN to keep the client waiting for up to timeout using
+// the given pending auth method (e.g. "webauth" or "crtext"). The client
+// must announce the method in IV_SSO, otherwise OpenVPN rejects it.
+func (a *Auth) Pending(timeout time.Duration, method, extra string) error {
+	if a.pendingFile == "" {
+		return fmt.Errorf("auth_pending_file not provided by OpenVPN")
+	}
+	data := fmt.Sprintf("%d\n%s\n%s\n", int(timeout.Seconds()), method, extra)
+	return utils.WriteFileAtomic(a.pendingFile, []byte(data), 0600)
+}
+
+// Finish writes the result to auth_control_file. Only the first result is
+// written, so a late result cannot override a deny written on timeout.
+func (a *Auth) Finish(allow bool) error {
+	err := ErrFinished
+	a.once.Do(func() {
+		result := "0"
+		if allow {
+			result = "1"
+		}
+		err = utils.WriteFileAtomic(a.controlFile, []byte(result), 0600)
+	})
+	return err
+}
+
+// Connect reports the result of a deferred client-connect through
+// client_connect_deferred_file (OpenVPN 2.5+)
+type Connect struct {
+	deferredFile string
+	configFile   string
+
+	once sync.Once
+}
+
+// ConnectFromEnv returns the deferred client-connect files provided by
+// OpenVPN, or nil when client_connect_deferred_file is not set
+func ConnectFromEnv() *Connect {
+	deferred := os.Getenv("client_connect_deferred_file")
+	if deferred == "" {
+		return nil
+	}
+	return &Connect{
+		deferredFile: deferred,
+		configFile:   os.Getenv("client_connect_config_file"),
+	}
+}
+
+// Defer tells OpenVPN that the result will be written later. The hook must
+// still exit with 0.
+func (c *Connect) Defer() error {
+	return os.WriteFile(c.deferredFile, []byte("2"), 0600)
+}
+
+// Finish writes the client config (when allowed) and then the result.
+// Only the first result is written; later calls return ErrFinished.
+func (c *Connect) Finish(config []byte, allow bool) error {
+	err := ErrFinished
+	c.once.Do(func() {
+		err = nil
+		result := "0"
+		if allow {
+			result = "1"
+			// A client must not be admitted without its config
+			if c.configFile != "" {
+				if err = utils.WriteFileAtomic(c.configFile, config, 0644); err != nil {
+					result = "0"
+				}
+			}
+		}
+		if werr := utils.WriteFileAtomic(c.deferredFile, []byte(result), 0600); werr != nil {
+			err = werr
+		}
+	})
+	return err
+}
+
+// Spawn starts the current executable with args as a detached worker in its
+// own session. stdin is passed through a pipe, so secrets never appear in
+// the worker's arguments or environment. The environment is inherited.
+func Spawn(stdin []byte, args ...string) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to locate executable: %w", err)
+	}
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = w.Close() }()
+
+	cmd := exec.Command(exe, args...)
+	cmd.Stdin = r
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+
+	err = cmd.Start()
+	_ = r.Close()
+	if err != nil {
+		return fmt.Errorf("failed to start worker: %w", err)
+	}
+	if _, err := w.Write(stdin); err != nil {
+		_ = cmd.Process.Kill()
+		return fmt.Errorf("failed to pass input to worker: %w", err)
+	}
+	return cmd.Process.Release()
+}
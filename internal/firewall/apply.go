@@ -0,0 +1,143 @@
+package firewall
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+
+	"github.com/tldr-it-stepankutaj/openvpn-client/internal/utils"
+)
+
+const backupSuffix = ".bak"
+
+// ErrValidation is returned by Apply when the generated rules fail validation
+var ErrValidation = errors.New("rules validation failed")
+
+// ReloadError is returned by Apply when the reload command fails
+type ReloadError struct {
+	Command     string
+	Output      string
+	Err         error
+	RolledBack  bool  // previous rules were restored and reloaded
+	RollbackErr error // error restoring the previous rules, if any
+}
+
+func (e *ReloadError) Error() string {
+	msg := fmt.Sprintf("reload command failed: %v", e.Err)
+	if e.RolledBack {
+		return msg + " (previous rules restored)"
+	}
+	if e.RollbackErr != nil {
+		return fmt.Sprintf("%s (rollback failed: %v)", msg, e.RollbackErr)
+	}
+	return msg
+}
+
+func (e *ReloadError) Unwrap() error {
+	return e.Err
+}
+
+// Apply installs rules into the firewall's rules file and reloads the firewall.
+// The rules are written to a temporary file, validated and renamed into place.
+// The previous file is kept as <rules_file>.bak and is restored and reloaded
+// automatically if the reload fails; without one, the new file is removed.
+func Apply(ctx context.Context, fw Firewall, rules string) error {
+	rulesFile := fw.GetRulesFile()
+
+	tmp, err := utils.WriteTempFile(rulesFile, []byte(rules), 0644)
+	if err != nil {
+		return fmt.Errorf("failed to write temporary rules file: %w", err)
+	}
+	defer func() {
+		_ = os.Remove(tmp)
+	}()
+
+	if err := fw.Validate(ctx, tmp); err != nil {
+		return fmt.Errorf("%w: %w", ErrValidation, err)
+	}
+
+	// Keep a backup of the current rules
+	oldRules, err := os.ReadFile(rulesFile)
+	hasBackup := err == nil
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to read current rules file: %w", err)
+	}
+	if hasBackup {
+		if err := utils.WriteFileAtomic(rulesFile+backupSuffix, oldRules, 0644); err != nil {
+			return fmt.Errorf("failed to write backup rules file: %w", err)
+		}
+	}
+
+	if err := os.Rename(tmp, rulesFile); err != nil {
+		return fmt.Errorf("failed to install rules file: %w", err)
+	}
+
+	output, err := Reload(ctx, fw)
+	if err == nil {
+		return nil
+	}
+
+	reloadErr := &ReloadError{
+		Command: fw.GetReloadCommand(),
+		Output:  output,
+		Err:     err,
+	}
+
+	if !hasBackup {
+		// Do not leave rules that failed to load for the next reload or boot
+		if err := os.Remove(rulesFile); err != nil {
+			reloadErr.RollbackErr = fmt.Errorf("failed to remove rules file: %w", err)
+			return reloadErr
+		}
+		reloadErr.RollbackErr = errors.New("no previous rules file to restore")
+		return reloadErr
+	}
+
+	if err := utils.WriteFileAtomic(rulesFile, oldRules, 0644); err != nil {
+		reloadErr.RollbackErr = err
+		return reloadErr
+	}
+	if output, err := Reload(ctx, fw); err != nil {
+		reloadErr.RollbackErr = fmt.Errorf("%w: %s", err, output)
+		return reloadErr
+	}
+
+	reloadErr.RolledBack = true
+	return reloadErr
+}
+
+// Reload runs the firewall's reload command and returns its combined output
+func Reload(ctx context.Context, fw Firewall) (string, error) {
+	cmd := exec.CommandContext(ctx, "sh", "-c", fw.GetReloadCommand())
+	output, err := cmd.CombinedOutput()
+	return string(output), err
+}
+
+// runValidator runs a validation command if its binary is available.
+// Validation is skipped when the tool is not installed.
+func runValidator(ctx context.Context, stdin string, name string, args ...string) error {
+	path, err := exec.LookPath(name)
+	if err != nil {
+		return nil
+	}
+
+	cmd := exec.CommandContext(ctx, path, args...)
+	if stdin != "" {
+		f, err := os.Open(stdin)
+		if err != nil {
+			return err
+		}
+		defer func(f *os.File) {
+			_ = f.Close()
+		}(f)
+		cmd.Stdin = f
+	}
+
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s: %w: %s", name, err, string(output))
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package firewall
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// fakeFirewall installs rules into rulesFile and reloads with reload
+type fakeFirewall struct {
+	Firewall
+	rulesFile string
+	reload    string
+}
+
+func (f *fakeFirewall) GetRulesFile() string                   { return f.rulesFile }
+func (f *fakeFirewall) GetReloadCommand() string               { return f.reload }
+func (f *fakeFirewall) Validate(context.Context, string) error { return nil }
+
+func TestApplyReloadFailure(t *testing.T) {
+	tests := []struct {
+		name         string
+		previous     string // current rules file; empty means none
+		wantRules    string // rules file after Apply; empty means removed
+		wantRestored bool
+	}{
+		{"previous rules restored", "old rules\n", "old rules\n", true},
+		{"new rules removed", "", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			rulesFile := filepath.Join(dir, "rules")
+			if tt.previous != "" {
+				if err := os.WriteFile(rulesFile, []byte(tt.previous), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			// Fails while the new rules are installed, succeeds after a rollback
+			fw := &fakeFirewall{rulesFile: rulesFile, reload: "! grep -q new " + rulesFile}
+			err := Apply(context.Background(), fw, "new rules\n")
+
+			var reloadErr *ReloadError
+			if !errors.As(err, &reloadErr) {
+				t.Fatalf("Apply = %v, want a ReloadError", err)
+			}
+			if reloadErr.RolledBack != tt.wantRestored {
+				t.Errorf("RolledBack = %v, want %v", reloadErr.RolledBack, tt.wantRestored)
+			}
+
+			data, err := os.ReadFile(rulesFile)
+			switch {
+			case tt.wantRules == "" && !os.IsNotExist(err):
+				t.Errorf("rules file left in place after a failed reload: %q, %v", data, err)
+			case tt.wantRules != "" && string(data) != tt.wantRules:
+				t.Errorf("rules file = %q, %v, want %q", data, err, tt.wantRules)
+			}
+		})
+	}
+}
@@ -0,0 +1,170 @@
+package firewall
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// AddressChange describes a user whose VPN address changed
+type AddressChange struct {
+	Old string `json:"old"`
+	New string `json:"new"`
+}
+
+// Diff describes the change between two rulesets at the user/network level
+type Diff struct {
+	UsersAdded       []string                 `json:"users_added"`
+	UsersRemoved     []string                 `json:"users_removed"`
+	AddressesChanged map[string]AddressChange `json:"addresses_changed"`
+	NetworksGranted  map[string][]string      `json:"networks_granted"` // keyed by username
+	NetworksRevoked  map[string][]string      `json:"networks_revoked"` // keyed by username
+}
+
+// ComputeDiff compares the current ruleset with the next one
+func ComputeDiff(current, next []UserWithNetworks) *Diff {
+	d := &Diff{
+		UsersAdded:       []string{},
+		UsersRemoved:     []string{},
+		AddressesChanged: map[string]AddressChange{},
+		NetworksGranted:  map[string][]string{},
+		NetworksRevoked:  map[string][]string{},
+	}
+
+	oldByUser := indexUsers(current)
+	newByUser := indexUsers(next)
+
+	for username, oldUser := range oldByUser {
+		newUser, ok := newByUser[username]
+		if !ok {
+			d.UsersRemoved = append(d.UsersRemoved, username)
+			d.addNetworks(d.NetworksRevoked, username, oldUser.Networks)
+			continue
+		}
+
+		if oldUser.VpnIP != newUser.VpnIP {
+			d.AddressesChanged[username] = AddressChange{Old: oldUser.VpnIP, New: newUser.VpnIP}
+		}
+		d.addNetworks(d.NetworksRevoked, username, subtract(oldUser.Networks, newUser.Networks))
+		d.addNetworks(d.NetworksGranted, username, subtract(newUser.Networks, oldUser.Networks))
+	}
+
+	for username, newUser := range newByUser {
+		if _, ok := oldByUser[username]; !ok {
+			d.UsersAdded = append(d.UsersAdded, username)
+			d.addNetworks(d.NetworksGranted, username, newUser.Networks)
+		}
+	}
+
+	sort.Strings(d.UsersAdded)
+	sort.Strings(d.UsersRemoved)
+	return d
+}
+
+// Empty reports whether the diff contains no changes
+func (d *Diff) Empty() bool {
+	return len(d.UsersAdded) == 0 && len(d.UsersRemoved) == 0 &&
+		len(d.AddressesChanged) == 0 && len(d.NetworksGranted) == 0 && len(d.NetworksRevoked) == 0
+}
+
+// GrantedCount returns the number of user/network pairs granted
+func (d *Diff) GrantedCount() int {
+	return countNetworks(d.NetworksGranted)
+}
+
+// RevokedCount returns the number of user/network pairs revoked
+func (d *Diff) RevokedCount() int {
+	return countNetworks(d.NetworksRevoked)
+}
+
+// LogAttrs returns the diff as structured log attributes
+func (d *Diff) LogAttrs() []any {
+	return []any{
+		"users_added", d.UsersAdded,
+		"users_removed", d.UsersRemoved,
+		"addresses_changed", len(d.AddressesChanged),
+		"networks_granted", d.GrantedCount(),
+		"networks_revoked", d.RevokedCount(),
+	}
+}
+
+// String returns a human-readable representation of the diff
+func (d *Diff) String() string {
+	if d.Empty() {
+		return "no changes\n"
+	}
+
+	var out strings.Builder
+	for _, username := range d.UsersAdded {
+		out.WriteString(fmt.Sprintf("+ user %s\n", username))
+	}
+	for _, username := range d.UsersRemoved {
+		out.WriteString(fmt.Sprintf("- user %s\n", username))
+	}
+	for _, username := range sortedKeys(d.AddressesChanged) {
+		change := d.AddressesChanged[username]
+		out.WriteString(fmt.Sprintf("~ user %s address %s -> %s\n", username, change.Old, change.New))
+	}
+	for _, username := range sortedKeys(d.NetworksGranted) {
+		for _, network := range d.NetworksGranted[username] {
+			out.WriteString(fmt.Sprintf("+ %s -> %s\n", username, network))
+		}
+	}
+	for _, username := range sortedKeys(d.NetworksRevoked) {
+		for _, network := range d.NetworksRevoked[username] {
+			out.WriteString(fmt.Sprintf("- %s -> %s\n", username, network))
+		}
+	}
+	out.WriteString(fmt.Sprintf("%d users added, %d removed, %d networks granted, %d revoked\n",
+		len(d.UsersAdded), len(d.UsersRemoved), d.GrantedCount(), d.RevokedCount()))
+	return out.String()
+}
+
+func (d *Diff) addNetworks(m map[string][]string, username string, networks []string) {
+	if len(networks) == 0 {
+		return
+	}
+	sorted := append([]string(nil), networks...)
+	sort.Strings(sorted)
+	m[username] = sorted
+}
+
+func indexUsers(users []UserWithNetworks) map[string]UserWithNetworks {
+	m := make(map[string]UserWithNetworks, len(users))
+	for _, user := range users {
+		m[user.Username] = user
+	}
+	return m
+}
+
+// subtract returns the elements of a that are not in b
+func subtract(a, b []string) []string {
+	in := make(map[string]bool, len(b))
+	for _, v := range b {
+		in[v] = true
+	}
+	var result []string
+	for _, v := range a {
+		if !in[v] {
+			result = append(result, v)
+		}
+	}
+	return result
+}
+
+func countNetworks(m map[string][]string) int {
+	n := 0
+	for _, networks := range m {
+		n += len(networks)
+	}
+	return n
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -2,6 +2,12 @@ package firewall
 
 import (
 	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/tldr-it-stepankutaj/openvpn-client/internal/api"
 	"github.com/tldr-it-stepankutaj/openvpn-client/internal/config"
@@ -12,16 +18,31 @@ type UserWithNetworks struct {
 	Username string
 	VpnIP    string
 	Networks []string
+
+	// Validity window of the user; nil bounds are open
+	ValidFrom *time.Time
+	ValidTo   *time.Time
+
+	// Schedules of networks that are only available at certain times
+	Schedules map[string]*api.Schedule
 }
 
 // Firewall is the interface for firewall rule generators
 type Firewall interface {
 	// GenerateRules generates firewall rules for the given users
 	GenerateRules(users []UserWithNetworks) string
+	// GenerateGroupRules generates firewall rules with one set of sources and
+	// destinations per group
+	GenerateGroupRules(groups []GroupWithMembers) string
+	// ParseRules parses a rules file produced by GenerateRules or
+	// GenerateGroupRules back into per-user networks
+	ParseRules(rules string) []UserWithNetworks
 	// GetRulesFile returns the path to the rule file
 	GetRulesFile() string
 	// GetReloadCommand returns the command to reload firewall rules
 	GetReloadCommand() string
+	// Validate checks a generated rules file without applying it
+	Validate(ctx context.Context, rulesFile string) error
 }
 
 // New creates a new firewall based on configuration
@@ -34,38 +55,201 @@ func New(cfg *config.FirewallConfig) Firewall {
 	}
 }
 
-// CollectUserNetworks collects networks for all users from API
-func CollectUserNetworks(ctx context.Context, client *api.Client, users []api.UserResponse) ([]UserWithNetworks, error) {
-	var result []UserWithNetworks
+// CollectError reports users whose routes could not be fetched
+type CollectError struct {
+	Errors map[string]error // keyed by username
+}
 
-	for _, user := range users {
-		if user.VpnIP == "" {
+func (e *CollectError) Error() string {
+	msgs := make([]string, 0, len(e.Errors))
+	for _, username := range e.Usernames() {
+		msgs = append(msgs, fmt.Sprintf("%s: %v", username, e.Errors[username]))
+	}
+	return fmt.Sprintf("failed to fetch routes for %d users: %s", len(e.Errors), strings.Join(msgs, "; "))
+}
+
+// Usernames returns the users whose routes could not be fetched
+func (e *CollectError) Usernames() []string {
+	names := make([]string, 0, len(e.Errors))
+	for username := range e.Errors {
+		names = append(names, username)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// CollectOptions controls how routes are fetched from the API
+type CollectOptions struct {
+	Concurrency    int           // maximum number of parallel requests (default 1)
+	RequestTimeout time.Duration // timeout per GetUserRoutes call (0 = client default)
+}
+
+// CollectUserNetworks collects networks for all users from API using a
+// bounded worker pool. Results are returned in the order of users.
+// Users whose routes cannot be fetched are left out of the result and
+// reported in a *CollectError returned alongside the partial result.
+func CollectUserNetworks(ctx context.Context, client *api.Client, users []api.UserResponse, opts CollectOptions) ([]UserWithNetworks, error) {
+	results := make([]*UserWithNetworks, len(users))
+	errs := runPool(ctx, users, opts, func(ctx context.Context, i int) error {
+		var err error
+		results[i], err = collectUser(ctx, client, &users[i])
+		return err
+	})
+
+	var result []UserWithNetworks
+	failed := make(map[string]error)
+	for i := range users {
+		if errs[i] != nil {
+			failed[users[i].Username] = errs[i]
 			continue
 		}
+		if results[i] != nil {
+			result = append(result, *results[i])
+		}
+	}
+
+	if len(failed) > 0 {
+		return result, &CollectError{Errors: failed}
+	}
+	return result, nil
+}
+
+// runPool calls fn for every user with a VPN IP using a bounded worker pool
+// and returns the per-user errors indexed like users
+func runPool(ctx context.Context, users []api.UserResponse, opts CollectOptions, fn func(ctx context.Context, i int) error) []error {
+	workers := opts.Concurrency
+	if workers < 1 {
+		workers = 1
+	}
 
-		routes, err := client.GetUserRoutes(ctx, user.ID)
-		if err != nil {
-			// Skip user on error, don't fail the entire operation
+	errs := make([]error, len(users))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				errs[i] = callWithTimeout(ctx, opts.RequestTimeout, func(ctx context.Context) error {
+					return fn(ctx, i)
+				})
+			}
+		}()
+	}
+
+	for i := range users {
+		if users[i].VpnIP == "" {
 			continue
 		}
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
 
-		networks := make([]string, 0)
-		for _, route := range routes {
-			// Skip default route for firewall rules
-			if route.CIDR == "0.0.0.0/0" || route.CIDR == "0/0" {
-				continue
-			}
+	return errs
+}
+
+// callWithTimeout calls fn with a context limited by timeout (0 = no limit)
+func callWithTimeout(ctx context.Context, timeout time.Duration, fn func(ctx context.Context) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	return fn(ctx)
+}
+
+// collectUser fetches routes for a single user. Returns nil if the user has
+// no networks that need firewall rules.
+func collectUser(ctx context.Context, client *api.Client, user *api.UserResponse) (*UserWithNetworks, error) {
+	routes, err := client.GetUserRoutes(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return userNetworks(user, routes), nil
+}
+
+// CollectUserNetworksBulk collects networks for all active users from the
+// bulk users-with-routes endpoint in a single paginated stream. Returns the
+// users with firewall rules and the number of active users received.
+// Inactive users are skipped; like the per-user path, the validity window
+// is left to the caller (UserWithNetworks.CheckValidity).
+func CollectUserNetworksBulk(ctx context.Context, client *api.Client) ([]UserWithNetworks, int, error) {
+	var result []UserWithNetworks
+	total := 0
+
+	err := client.StreamUsersWithRoutes(ctx, func(user *api.UserWithRoutes) error {
+		if !user.IsActive {
+			return nil
+		}
+		total++
+		if user.VpnIP == "" {
+			return nil
+		}
+		if u := userNetworks(&user.UserResponse, user.Routes); u != nil {
+			result = append(result, *u)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, total, err
+	}
+	return result, total, nil
+}
+
+// userNetworks converts user routes into firewall networks.
+// Returns nil if the user has no networks that need firewall rules.
+func userNetworks(user *api.UserResponse, routes []api.Network) *UserWithNetworks {
+	networks := make([]string, 0)
+	schedules := make(map[string]*api.Schedule)
+	unscheduled := make(map[string]bool)
+	for _, route := range routes {
+		// Skip default route for firewall rules
+		if route.CIDR == "0.0.0.0/0" || route.CIDR == "0/0" {
+			continue
+		}
+		if _, ok := schedules[route.CIDR]; !ok && !unscheduled[route.CIDR] {
 			networks = append(networks, route.CIDR)
 		}
+		mergeSchedule(schedules, unscheduled, route.CIDR, route.Schedule)
+	}
+
+	if len(networks) == 0 {
+		return nil
+	}
+	return &UserWithNetworks{
+		Username:  user.Username,
+		VpnIP:     user.VpnIP,
+		Networks:  networks,
+		ValidFrom: user.ValidFrom,
+		ValidTo:   user.ValidTo,
+		Schedules: schedules,
+	}
+}
+
+// CheckValidity returns an error if the user is outside their validity window at t
+func (u *UserWithNetworks) CheckValidity(t time.Time, skew time.Duration) error {
+	return api.CheckValidity(u.ValidFrom, u.ValidTo, t, skew)
+}
 
-		if len(networks) > 0 {
-			result = append(result, UserWithNetworks{
-				Username: user.Username,
-				VpnIP:    user.VpnIP,
-				Networks: networks,
-			})
+// appendMissing appends the networks that are not in list yet
+func appendMissing(list []string, networks ...string) []string {
+	for _, network := range networks {
+		if !slices.Contains(list, network) {
+			list = append(list, network)
 		}
 	}
+	return list
+}
 
-	return result, nil
+// parseComment returns the username from a "# username" line
+func parseComment(line string) (string, bool) {
+	if !strings.HasPrefix(line, "# ") {
+		return "", false
+	}
+	return strings.TrimSpace(strings.TrimPrefix(line, "# ")), true
 }
@@ -0,0 +1,127 @@
+package firewall
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tldr-it-stepankutaj/openvpn-client/internal/api"
+	"github.com/tldr-it-stepankutaj/openvpn-client/internal/config"
+)
+
+// fakeRoutesAPI serves /api/v1/vpn-auth/users/<n>/routes with one route
+// 10.<n/256>.<n%256>.0/24 per user after the delay returned for the user
+func fakeRoutesAPI(tb testing.TB, delay func(n int) time.Duration) *api.Client {
+	tb.Helper()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/api/v1/vpn-auth/users/"), "/routes")
+		n, err := strconv.Atoi(id)
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		time.Sleep(delay(n))
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(api.RoutesResponse{Routes: []api.Network{
+			{ID: id, CIDR: fmt.Sprintf("10.%d.%d.0/24", n/256, n%256)},
+		}})
+	}))
+	tb.Cleanup(srv.Close)
+
+	return api.NewClient(&config.APIConfig{BaseURL: srv.URL, Token: "test", Timeout: 10 * time.Second})
+}
+
+// fakeUsers returns n users with VPN IPs and IDs "0".."n-1"
+func fakeUsers(n int) []api.UserResponse {
+	users := make([]api.UserResponse, n)
+	for i := range users {
+		users[i] = api.UserResponse{
+			ID:       strconv.Itoa(i),
+			Username: fmt.Sprintf("user%03d", i),
+			IsActive: true,
+			VpnIP:    fmt.Sprintf("10.8.%d.%d", i/256, i%256+1),
+		}
+	}
+	return users
+}
+
+func TestCollectUserNetworksKeepsOrder(t *testing.T) {
+	const n = 32
+	// Later users answer first, so completion order is reversed
+	client := fakeRoutesAPI(t, func(i int) time.Duration {
+		return time.Duration(n-i) * time.Millisecond
+	})
+	users := fakeUsers(n)
+	users[5].VpnIP = "" // users without an address are skipped
+
+	result, err := CollectUserNetworks(context.Background(), client, users, CollectOptions{Concurrency: 8})
+	if err != nil {
+		t.Fatalf("CollectUserNetworks: %v", err)
+	}
+	if len(result) != n-1 {
+		t.Fatalf("got %d users, want %d", len(result), n-1)
+	}
+
+	j := 0
+	for i := range users {
+		if users[i].VpnIP == "" {
+			continue
+		}
+		if got := result[j].Username; got != users[i].Username {
+			t.Errorf("result[%d] = %s, want %s", j, got, users[i].Username)
+		}
+		want := fmt.Sprintf("10.%d.%d.0/24", i/256, i%256)
+		if len(result[j].Networks) != 1 || result[j].Networks[0] != want {
+			t.Errorf("result[%d] networks = %v, want [%s]", j, result[j].Networks, want)
+		}
+		j++
+	}
+}
+
+func BenchmarkCollectUserNetworks(b *testing.B) {
+	const users = 50
+	const latency = 2 * time.Millisecond
+
+	for _, concurrency := range []int{1, 8, 32} {
+		b.Run(fmt.Sprintf("concurrency=%d", concurrency), func(b *testing.B) {
+			client := fakeRoutesAPI(b, func(int) time.Duration { return latency })
+			list := fakeUsers(users)
+			opts := CollectOptions{Concurrency: concurrency}
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := CollectUserNetworks(context.Background(), client, list, opts); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestCollectUserNetworksBulkSkipsInactive(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"users": [
+			{"username": "alice", "is_active": true, "vpn_ip": "10.8.0.2", "routes": [{"cidr": "10.1.0.0/24"}]},
+			{"username": "bob", "is_active": false, "vpn_ip": "10.8.0.3", "routes": [{"cidr": "10.2.0.0/24"}]}
+		], "total_pages": 1}`))
+	}))
+	defer srv.Close()
+	client := api.NewClient(&config.APIConfig{BaseURL: srv.URL, Token: "test", Timeout: 10 * time.Second})
+
+	users, total, err := CollectUserNetworksBulk(context.Background(), client)
+	if err != nil {
+		t.Fatalf("CollectUserNetworksBulk: %v", err)
+	}
+	if total != 1 || len(users) != 1 || users[0].Username != "alice" {
+		t.Errorf("got %d users of %d, want only alice: %+v", len(users), total, users)
+	}
+}
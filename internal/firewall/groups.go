@@ -0,0 +1,204 @@
+package firewall
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/tldr-it-stepankutaj/openvpn-client/internal/api"
+	"github.com/tldr-it-stepankutaj/openvpn-client/internal/utils"
+)
+
+// userGroupPrefix names pseudo-groups holding a single user's networks
+const userGroupPrefix = "user:"
+
+// GroupMember represents a user's VPN address within a group
+type GroupMember struct {
+	Username string
+	VpnIP    string
+}
+
+// GroupWithMembers represents a group with its member addresses and
+// destination networks. Rules generated from groups scale with
+// groups x networks instead of users x networks.
+type GroupWithMembers struct {
+	Name     string
+	Members  []GroupMember
+	Networks []string
+
+	// Schedules of networks that are only available at certain times
+	Schedules map[string]*api.Schedule
+}
+
+// CollectGroupNetworks collects group membership for all users from API using
+// a bounded worker pool. Groups are returned sorted by name with members in
+// the order of users. Users whose groups cannot be fetched are reported in a
+// *CollectError returned alongside the partial result.
+func CollectGroupNetworks(ctx context.Context, client *api.Client, users []api.UserResponse, opts CollectOptions) ([]GroupWithMembers, error) {
+	userGroups := make([][]api.GroupWithNetworks, len(users))
+	errs := runPool(ctx, users, opts, func(ctx context.Context, i int) error {
+		var err error
+		userGroups[i], err = client.GetUserGroups(ctx, users[i].ID)
+		return err
+	})
+
+	byName := make(map[string]*GroupWithMembers)
+	failed := make(map[string]error)
+	for i, user := range users {
+		if errs[i] != nil {
+			failed[user.Username] = errs[i]
+			continue
+		}
+		for _, group := range userGroups[i] {
+			g, ok := byName[group.Name]
+			if !ok {
+				networks, schedules := groupNetworks(&group)
+				g = &GroupWithMembers{Name: group.Name, Networks: networks, Schedules: schedules}
+				byName[group.Name] = g
+			}
+			g.Members = append(g.Members, GroupMember{Username: user.Username, VpnIP: user.VpnIP})
+		}
+	}
+
+	groups := make([]GroupWithMembers, 0, len(byName))
+	for _, name := range sortedKeys(byName) {
+		if g := byName[name]; len(g.Networks) > 0 {
+			groups = append(groups, *g)
+		}
+	}
+
+	if len(failed) > 0 {
+		return groups, &CollectError{Errors: failed}
+	}
+	return groups, nil
+}
+
+// GroupsFromUsers wraps each user in a single-member pseudo-group named
+// "user:<username>", e.g. to keep previous rules of users that could not be
+// refreshed in group mode
+func GroupsFromUsers(users []UserWithNetworks) []GroupWithMembers {
+	groups := make([]GroupWithMembers, 0, len(users))
+	for _, user := range users {
+		groups = append(groups, GroupWithMembers{
+			Name:      userGroupPrefix + user.Username,
+			Members:   []GroupMember{{Username: user.Username, VpnIP: user.VpnIP}},
+			Networks:  user.Networks,
+			Schedules: user.Schedules,
+		})
+	}
+	return groups
+}
+
+// ExpandGroups converts group rules into the per-user networks they grant,
+// ordered by username
+func ExpandGroups(groups []GroupWithMembers) []UserWithNetworks {
+	byUser := make(map[string]*UserWithNetworks)
+	seen := make(map[string]map[string]bool)
+
+	for _, group := range groups {
+		for _, member := range group.Members {
+			u, ok := byUser[member.Username]
+			if !ok {
+				u = &UserWithNetworks{Username: member.Username, VpnIP: member.VpnIP}
+				byUser[member.Username] = u
+				seen[member.Username] = make(map[string]bool)
+			}
+			for _, network := range group.Networks {
+				if !seen[member.Username][network] {
+					seen[member.Username][network] = true
+					u.Networks = append(u.Networks, network)
+				}
+			}
+		}
+	}
+
+	users := make([]UserWithNetworks, 0, len(byUser))
+	for _, username := range sortedKeys(byUser) {
+		u := byUser[username]
+		sort.Strings(u.Networks)
+		users = append(users, *u)
+	}
+	return users
+}
+
+// groupNetworks returns the group's networks without default routes and
+// their schedules. A network's own schedule takes precedence over the group's.
+func groupNetworks(group *api.GroupWithNetworks) ([]string, map[string]*api.Schedule) {
+	result := make([]string, 0, len(group.Networks))
+	schedules := make(map[string]*api.Schedule)
+	unscheduled := make(map[string]bool)
+	for _, network := range group.Networks {
+		if utils.IsDefaultRoute(network.CIDR) {
+			continue
+		}
+		if _, ok := schedules[network.CIDR]; !ok && !unscheduled[network.CIDR] {
+			result = append(result, network.CIDR)
+		}
+		schedule := network.Schedule
+		if schedule == nil {
+			schedule = group.Schedule
+		}
+		mergeSchedule(schedules, unscheduled, network.CIDR, schedule)
+	}
+	sort.Strings(result)
+	return result, schedules
+}
+
+// groupParser collects group blocks while parsing a rules file
+type groupParser struct {
+	groups  []GroupWithMembers
+	current *GroupWithMembers
+}
+
+// parseLine handles "# group <name>" and "# member <username> <ip>" comments.
+// Returns false for other lines; any other comment ends the current group.
+func (p *groupParser) parseLine(line string) bool {
+	fields := strings.Fields(line)
+	switch {
+	case len(fields) >= 3 && fields[0] == "#" && fields[1] == "group":
+		p.groups = append(p.groups, GroupWithMembers{Name: strings.Join(fields[2:], " ")})
+		p.current = &p.groups[len(p.groups)-1]
+		return true
+	case len(fields) == 4 && fields[0] == "#" && fields[1] == "member" && p.current != nil:
+		p.current.Members = append(p.current.Members, GroupMember{Username: fields[2], VpnIP: fields[3]})
+		return true
+	case strings.HasPrefix(line, "#"):
+		p.current = nil
+	}
+	return false
+}
+
+// addNetworks adds networks to the current group
+func (p *groupParser) addNetworks(networks ...string) bool {
+	if p.current == nil {
+		return false
+	}
+	p.current.Networks = append(p.current.Networks, networks...)
+	return true
+}
+
+// writeGroupHeader writes the group and member comments
+func writeGroupHeader(rules *strings.Builder, group *GroupWithMembers) {
+	rules.WriteString(fmt.Sprintf("# group %s\n", group.Name))
+	for _, member := range group.Members {
+		rules.WriteString(fmt.Sprintf("# member %s %s\n", member.Username, member.VpnIP))
+	}
+}
+
+// sortGroup sorts members and networks for a consistent output
+func sortGroup(group *GroupWithMembers) {
+	sort.Slice(group.Members, func(i, j int) bool {
+		return group.Members[i].Username < group.Members[j].Username
+	})
+	sort.Strings(group.Networks)
+}
+
+// memberAddresses returns the VPN addresses of the group's members
+func memberAddresses(group *GroupWithMembers) []string {
+	addrs := make([]string, 0, len(group.Members))
+	for _, member := range group.Members {
+		addrs = append(addrs, member.VpnIP)
+	}
+	return addrs
+}
@@ -1,14 +1,21 @@
 package firewall
 
 import (
+	"context"
 	"fmt"
+	"hash/crc32"
 	"sort"
 	"strings"
+	"time"
 
 	"github.com/tldr-it-stepankutaj/openvpn-client/internal/config"
 )
 
-const defaultChainName = "VPN_USERS"
+const (
+	defaultChainName = "VPN_USERS"
+	// iptables chain names are limited to 28 characters: prefix + "_G" + 8 hex digits
+	maxChainPrefix = 18
+)
 
 // IPTables implements Firewall interface for iptables
 type IPTables struct {
@@ -32,6 +39,9 @@ func NewIPTables(cfg *config.IPTablesConfig) *IPTables {
 
 // GenerateRules generates iptables rules for the given users
 func (i *IPTables) GenerateRules(users []UserWithNetworks) string {
+	// Never interpolate unvalidated API values into the ruleset
+	users, _ = SanitizeUsers(users)
+
 	var rules strings.Builder
 	rules.WriteString("# Auto-generated VPN user rules (iptables)\n")
 	rules.WriteString("# Do not edit manually - changes will be overwritten\n\n")
@@ -46,16 +56,130 @@ func (i *IPTables) GenerateRules(users []UserWithNetworks) string {
 		sort.Strings(user.Networks)
 
 		rules.WriteString(fmt.Sprintf("# %s\n", user.Username))
-		for _, network := range user.Networks {
+		always, scheduled := splitScheduled(user.Networks, user.Schedules)
+		for _, network := range always {
 			rules.WriteString(fmt.Sprintf("-A %s -s %s -d %s -j ACCEPT\n",
 				i.chainName, user.VpnIP, network))
 		}
+		for _, s := range scheduled {
+			slots := scheduleSlots(s.schedule, time.Local)
+			for _, network := range s.networks {
+				for _, slot := range slots {
+					rules.WriteString(fmt.Sprintf("-A %s -s %s -d %s %s-j ACCEPT\n",
+						i.chainName, user.VpnIP, network, iptSlot(slot)))
+				}
+			}
+		}
 	}
 
 	rules.WriteString("COMMIT\n")
 	return rules.String()
 }
 
+// GenerateGroupRules generates iptables rules with one chain per group.
+// Members jump from the main chain to their group chains, which accept
+// the group's networks.
+func (i *IPTables) GenerateGroupRules(groups []GroupWithMembers) string {
+	// Never interpolate unvalidated API values into the ruleset
+	groups, _ = SanitizeGroups(groups)
+
+	var rules strings.Builder
+	rules.WriteString("# Auto-generated VPN group rules (iptables)\n")
+	rules.WriteString("# Do not edit manually - changes will be overwritten\n\n")
+	rules.WriteString("*filter\n")
+
+	// Create/flush chains
+	rules.WriteString(fmt.Sprintf(":%s - [0:0]\n", i.chainName))
+	for _, group := range groups {
+		rules.WriteString(fmt.Sprintf(":%s - [0:0]\n", i.groupChain(group.Name)))
+	}
+	rules.WriteString(fmt.Sprintf("-F %s\n", i.chainName))
+	for _, group := range groups {
+		rules.WriteString(fmt.Sprintf("-F %s\n", i.groupChain(group.Name)))
+	}
+
+	for _, group := range groups {
+		sortGroup(&group)
+		chain := i.groupChain(group.Name)
+
+		writeGroupHeader(&rules, &group)
+		for _, member := range group.Members {
+			rules.WriteString(fmt.Sprintf("-A %s -s %s -j %s\n", i.chainName, member.VpnIP, chain))
+		}
+		always, scheduled := splitScheduled(group.Networks, group.Schedules)
+		for _, network := range always {
+			rules.WriteString(fmt.Sprintf("-A %s -d %s -j ACCEPT\n", chain, network))
+		}
+		for _, s := range scheduled {
+			slots := scheduleSlots(s.schedule, time.Local)
+			for _, network := range s.networks {
+				for _, slot := range slots {
+					rules.WriteString(fmt.Sprintf("-A %s -d %s %s-j ACCEPT\n", chain, network, iptSlot(slot)))
+				}
+			}
+		}
+	}
+
+	rules.WriteString("COMMIT\n")
+	return rules.String()
+}
+
+// ParseRules parses rules generated by GenerateRules or GenerateGroupRules.
+// Group rules are expanded into the per-user networks they grant.
+func (i *IPTables) ParseRules(rules string) []UserWithNetworks {
+	var users []UserWithNetworks
+	var current *UserWithNetworks
+	var groups groupParser
+	username := ""
+
+	for _, line := range strings.Split(rules, "\n") {
+		line = strings.TrimSpace(line)
+		if groups.parseLine(line) {
+			current = nil
+			continue
+		}
+		if name, ok := parseComment(line); ok {
+			username = name
+			current = nil
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) < 6 || fields[0] != "-A" || !strings.HasSuffix(line, " -j ACCEPT") {
+			continue
+		}
+
+		// -A <group chain> -d <cidr> [-m time ...] -j ACCEPT
+		if fields[1] != i.chainName && fields[2] == "-d" {
+			groups.addNetworks(fields[3])
+			continue
+		}
+
+		// -A <chain> -s <ip> -d <cidr> [-m time ...] -j ACCEPT
+		if len(fields) < 8 || fields[1] != i.chainName || fields[2] != "-s" || fields[4] != "-d" {
+			continue
+		}
+
+		if current == nil || current.VpnIP != fields[3] {
+			users = append(users, UserWithNetworks{Username: username, VpnIP: fields[3]})
+			current = &users[len(users)-1]
+		}
+		current.Networks = appendMissing(current.Networks, fields[5])
+	}
+
+	return append(users, ExpandGroups(groups.groups)...)
+}
+
+// groupChain returns the chain name for a group. A hash of the group name
+// keeps it stable and within the 28 character limit.
+func (i *IPTables) groupChain(name string) string {
+	prefix := i.chainName
+	if len(prefix) > maxChainPrefix {
+		prefix = prefix[:maxChainPrefix]
+	}
+	return fmt.Sprintf("%s_G%08x", prefix, crc32.ChecksumIEEE([]byte(name)))
+}
+
 // GetRulesFile returns the path to the rule file
 func (i *IPTables) GetRulesFile() string {
 	return i.rulesFile
@@ -65,3 +189,9 @@ func (i *IPTables) GetRulesFile() string {
 func (i *IPTables) GetReloadCommand() string {
 	return i.reloadCommand
 }
+
+// Validate checks the rules file with "iptables-restore --test".
+// Skipped if iptables-restore is not installed.
+func (i *IPTables) Validate(ctx context.Context, rulesFile string) error {
+	return runValidator(ctx, rulesFile, "iptables-restore", "--test", "--noflush")
+}
@@ -0,0 +1,64 @@
+package firewall
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/tldr-it-stepankutaj/openvpn-client/internal/config"
+)
+
+var (
+	iptTable     = regexp.MustCompile(`^(\*filter|COMMIT|:VPN_USERS(_G[0-9a-f]{8})? - \[0:0\]|-F VPN_USERS(_G[0-9a-f]{8})?)$`)
+	iptUserRule  = regexp.MustCompile(`^-A VPN_USERS -s ` + ipv4 + ` -d ` + cidr4 + ` -j ACCEPT$`)
+	iptJumpRule  = regexp.MustCompile(`^-A VPN_USERS -s ` + ipv4 + ` -j VPN_USERS_G[0-9a-f]{8}$`)
+	iptGroupRule = regexp.MustCompile(`^-A VPN_USERS_G[0-9a-f]{8} -d ` + cidr4 + ` -j ACCEPT$`)
+)
+
+func FuzzIPTablesGenerateRules(f *testing.F) {
+	fuzzSeeds(f)
+
+	i := NewIPTables(&config.IPTablesConfig{})
+	f.Fuzz(func(t *testing.T, username, group, vpnIP, cidr string) {
+		if username == "alice" || group == "base" {
+			t.Skip("collides with the fixed entries")
+		}
+		userOK, groupOK := fuzzAccepted(username, group, vpnIP, cidr)
+
+		users := []UserWithNetworks{
+			{Username: "alice", VpnIP: "10.8.0.2", Networks: []string{"10.1.0.0/24"}},
+			{Username: username, VpnIP: vpnIP, Networks: []string{cidr}},
+		}
+		rules := i.GenerateRules(users)
+		checkRules(t, rules, headerComment, userComment, iptTable, iptUserRule)
+
+		want := 1
+		if userOK {
+			want++
+		}
+		if got := countLines(rules, iptUserRule); got != want {
+			t.Fatalf("got %d rules, want %d:\n%s", got, want, rules)
+		}
+		parsed := i.ParseRules(rules)
+		if len(parsed) != want || (userOK && parsed[1].Username != username) {
+			t.Fatalf("parsed %+v from:\n%s", parsed, rules)
+		}
+
+		groups := []GroupWithMembers{
+			{Name: "base", Members: []GroupMember{{Username: "alice", VpnIP: "10.8.0.2"}}, Networks: []string{"10.1.0.0/24"}},
+			{Name: group, Members: []GroupMember{{Username: username, VpnIP: vpnIP}}, Networks: []string{cidr}},
+		}
+		rules = i.GenerateGroupRules(groups)
+		checkRules(t, rules, headerComment, groupComment, memberComment, iptTable, iptJumpRule, iptGroupRule)
+
+		want = 1
+		if groupOK {
+			want++
+		}
+		if got := countLines(rules, iptJumpRule); got != want {
+			t.Fatalf("got %d group jumps, want %d:\n%s", got, want, rules)
+		}
+		if got := countLines(rules, iptGroupRule); got != want {
+			t.Fatalf("got %d group rules, want %d:\n%s", got, want, rules)
+		}
+	})
+}
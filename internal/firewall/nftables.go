@@ -1,17 +1,30 @@
 package firewall
 
 import (
+	"context"
 	"fmt"
+	"os"
 	"sort"
 	"strings"
+	"time"
 
 	"github.com/tldr-it-stepankutaj/openvpn-client/internal/config"
+	"github.com/tldr-it-stepankutaj/openvpn-client/internal/utils"
 )
 
+// checkTable is a throwaway table used to validate the rules fragment,
+// which is meant to be included inside a chain of the main configuration
+const checkTable = "openvpn_client_check"
+
+// metaTimeFormat is the date format of nftables "meta time" expressions,
+// which nft interprets in the local time zone
+const metaTimeFormat = "2006-01-02 15:04:05"
+
 // NFTables implements Firewall interface for nftables
 type NFTables struct {
 	rulesFile     string
 	reloadCommand string
+	timeBounds    bool
 }
 
 // NewNFTables creates a new NFTables firewall generator
@@ -19,11 +32,15 @@ func NewNFTables(cfg *config.NFTablesConfig) *NFTables {
 	return &NFTables{
 		rulesFile:     cfg.RulesFile,
 		reloadCommand: cfg.ReloadCommand,
+		timeBounds:    cfg.TimeBounds,
 	}
 }
 
 // GenerateRules generates nftables rules for the given users
 func (n *NFTables) GenerateRules(users []UserWithNetworks) string {
+	// Never interpolate unvalidated API values into the ruleset
+	users, _ = SanitizeUsers(users)
+
 	var rules strings.Builder
 	rules.WriteString("# Auto-generated VPN user rules (nftables)\n")
 	rules.WriteString("# Do not edit manually - changes will be overwritten\n\n")
@@ -33,14 +50,146 @@ func (n *NFTables) GenerateRules(users []UserWithNetworks) string {
 		sort.Strings(user.Networks)
 
 		rules.WriteString(fmt.Sprintf("# %s\n", user.Username))
-		rules.WriteString(fmt.Sprintf("ip saddr %s ip daddr { %s } accept\n",
-			user.VpnIP,
-			strings.Join(user.Networks, ", ")))
+		always, scheduled := splitScheduled(user.Networks, user.Schedules)
+		if len(always) > 0 {
+			rules.WriteString(fmt.Sprintf("ip saddr %s ip daddr { %s } %saccept\n",
+				user.VpnIP,
+				strings.Join(always, ", "),
+				n.timeExpr(&user)))
+		}
+		for _, s := range scheduled {
+			for _, slot := range scheduleSlots(s.schedule, time.Local) {
+				rules.WriteString(fmt.Sprintf("ip saddr %s ip daddr { %s } %s%saccept\n",
+					user.VpnIP,
+					strings.Join(s.networks, ", "),
+					nftSlot(slot),
+					n.timeExpr(&user)))
+			}
+		}
+	}
+
+	return rules.String()
+}
+
+// timeExpr returns "meta time" bounds for the user's validity window when
+// time bounds are enabled
+func (n *NFTables) timeExpr(user *UserWithNetworks) string {
+	if !n.timeBounds {
+		return ""
+	}
+
+	var expr strings.Builder
+	if user.ValidFrom != nil {
+		expr.WriteString(fmt.Sprintf("meta time >= \"%s\" ", user.ValidFrom.Local().Format(metaTimeFormat)))
+	}
+	if user.ValidTo != nil {
+		expr.WriteString(fmt.Sprintf("meta time < \"%s\" ", user.ValidTo.Local().Format(metaTimeFormat)))
+	}
+	return expr.String()
+}
+
+// GenerateGroupRules generates nftables rules with one rule per group
+func (n *NFTables) GenerateGroupRules(groups []GroupWithMembers) string {
+	// Never interpolate unvalidated API values into the ruleset
+	groups, _ = SanitizeGroups(groups)
+
+	var rules strings.Builder
+	rules.WriteString("# Auto-generated VPN group rules (nftables)\n")
+	rules.WriteString("# Do not edit manually - changes will be overwritten\n\n")
+
+	for _, group := range groups {
+		sortGroup(&group)
+
+		writeGroupHeader(&rules, &group)
+		members := strings.Join(memberAddresses(&group), ", ")
+		always, scheduled := splitScheduled(group.Networks, group.Schedules)
+		if len(always) > 0 {
+			rules.WriteString(fmt.Sprintf("ip saddr { %s } ip daddr { %s } accept\n",
+				members,
+				strings.Join(always, ", ")))
+		}
+		for _, s := range scheduled {
+			for _, slot := range scheduleSlots(s.schedule, time.Local) {
+				rules.WriteString(fmt.Sprintf("ip saddr { %s } ip daddr { %s } %saccept\n",
+					members,
+					strings.Join(s.networks, ", "),
+					nftSlot(slot)))
+			}
+		}
 	}
 
 	return rules.String()
 }
 
+// ParseRules parses rules generated by GenerateRules or GenerateGroupRules.
+// Group rules are expanded into the per-user networks they grant.
+func (n *NFTables) ParseRules(rules string) []UserWithNetworks {
+	var users []UserWithNetworks
+	var groups groupParser
+	username := ""
+
+	for _, line := range strings.Split(rules, "\n") {
+		line = strings.TrimSpace(line)
+		if groups.parseLine(line) {
+			continue
+		}
+		if name, ok := parseComment(line); ok {
+			username = name
+			continue
+		}
+
+		// ip saddr <ip> ip daddr { <cidr>, <cidr> } [meta day/hour/time ...] accept
+		// ip saddr { <ip>, <ip> } ip daddr { <cidr>, <cidr> } [meta day/hour ...] accept
+		if !strings.HasPrefix(line, "ip saddr ") {
+			continue
+		}
+		daddr := strings.Index(line, " ip daddr ")
+		if daddr < 0 {
+			continue
+		}
+		saddr := strings.TrimSpace(line[len("ip saddr "):daddr])
+		networks := parseSet(line[daddr+len(" ip daddr "):])
+
+		if strings.HasPrefix(saddr, "{") {
+			groups.addNetworks(networks...)
+			continue
+		}
+
+		// Further rules of the same user, e.g. for scheduled networks
+		if username == "" && len(users) > 0 && users[len(users)-1].VpnIP == saddr {
+			last := &users[len(users)-1]
+			last.Networks = appendMissing(last.Networks, networks...)
+			continue
+		}
+
+		users = append(users, UserWithNetworks{
+			Username: username,
+			VpnIP:    saddr,
+			Networks: networks,
+		})
+		username = ""
+	}
+
+	return append(users, ExpandGroups(groups.groups)...)
+}
+
+// parseSet returns the elements of the first "{ a, b }" set in s
+func parseSet(s string) []string {
+	start := strings.Index(s, "{")
+	end := strings.Index(s, "}")
+	if start < 0 || end < start {
+		return nil
+	}
+
+	var elements []string
+	for _, element := range strings.Split(s[start+1:end], ",") {
+		if element = strings.TrimSpace(element); element != "" {
+			elements = append(elements, element)
+		}
+	}
+	return elements
+}
+
 // GetRulesFile returns the path to the rule file
 func (n *NFTables) GetRulesFile() string {
 	return n.rulesFile
@@ -50,3 +199,20 @@ func (n *NFTables) GetRulesFile() string {
 func (n *NFTables) GetReloadCommand() string {
 	return n.reloadCommand
 }
+
+// Validate checks the rules file with "nft -c" by including it in a
+// throwaway chain. Skipped if nft is not installed.
+func (n *NFTables) Validate(ctx context.Context, rulesFile string) error {
+	wrapper := fmt.Sprintf("table inet %s {\n\tchain check {\n\t\tinclude \"%s\"\n\t}\n}\n",
+		checkTable, rulesFile)
+
+	tmp, err := utils.WriteTempFile(rulesFile, []byte(wrapper), 0600)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = os.Remove(tmp)
+	}()
+
+	return runValidator(ctx, "", "nft", "-c", "-f", tmp)
+}
@@ -0,0 +1,59 @@
+package firewall
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/tldr-it-stepankutaj/openvpn-client/internal/config"
+)
+
+var (
+	nftUserRule  = regexp.MustCompile(`^ip saddr ` + ipv4 + ` ip daddr \{ ` + cidr4 + `(, ` + cidr4 + `)* \} accept$`)
+	nftGroupRule = regexp.MustCompile(`^ip saddr \{ ` + ipv4 + `(, ` + ipv4 + `)* \} ip daddr \{ ` + cidr4 + `(, ` + cidr4 + `)* \} accept$`)
+)
+
+func FuzzNFTablesGenerateRules(f *testing.F) {
+	fuzzSeeds(f)
+
+	n := NewNFTables(&config.NFTablesConfig{})
+	f.Fuzz(func(t *testing.T, username, group, vpnIP, cidr string) {
+		if username == "alice" || group == "base" {
+			t.Skip("collides with the fixed entries")
+		}
+		userOK, groupOK := fuzzAccepted(username, group, vpnIP, cidr)
+
+		users := []UserWithNetworks{
+			{Username: "alice", VpnIP: "10.8.0.2", Networks: []string{"10.1.0.0/24"}},
+			{Username: username, VpnIP: vpnIP, Networks: []string{cidr}},
+		}
+		rules := n.GenerateRules(users)
+		checkRules(t, rules, headerComment, userComment, nftUserRule)
+
+		want := 1
+		if userOK {
+			want++
+		}
+		if got := countLines(rules, nftUserRule); got != want {
+			t.Fatalf("got %d rules, want %d:\n%s", got, want, rules)
+		}
+		parsed := n.ParseRules(rules)
+		if len(parsed) != want || (userOK && parsed[1].Username != username) {
+			t.Fatalf("parsed %+v from:\n%s", parsed, rules)
+		}
+
+		groups := []GroupWithMembers{
+			{Name: "base", Members: []GroupMember{{Username: "alice", VpnIP: "10.8.0.2"}}, Networks: []string{"10.1.0.0/24"}},
+			{Name: group, Members: []GroupMember{{Username: username, VpnIP: vpnIP}}, Networks: []string{cidr}},
+		}
+		rules = n.GenerateGroupRules(groups)
+		checkRules(t, rules, headerComment, groupComment, memberComment, nftGroupRule)
+
+		want = 1
+		if groupOK {
+			want++
+		}
+		if got := countLines(rules, nftGroupRule); got != want {
+			t.Fatalf("got %d group rules, want %d:\n%s", got, want, rules)
+		}
+	})
+}
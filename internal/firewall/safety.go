@@ -0,0 +1,66 @@
+package firewall
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/tldr-it-stepankutaj/openvpn-client/internal/config"
+)
+
+// SafetyError is returned when a ruleset change exceeds the configured thresholds
+type SafetyError struct {
+	Reasons []string
+}
+
+func (e *SafetyError) Error() string {
+	return "mass-change guard triggered: " + strings.Join(e.Reasons, "; ")
+}
+
+// CheckSafety checks the diff from the current ruleset and returns a *SafetyError
+// if the number of removed users or rules exceeds the configured thresholds.
+// An empty current ruleset (first run) is never blocked.
+func CheckSafety(current []UserWithNetworks, diff *Diff, cfg *config.SafetyConfig) error {
+	oldUsers, oldRules := countRules(current)
+	if oldUsers == 0 {
+		return nil
+	}
+
+	removedUsers, removedRules := len(diff.UsersRemoved), diff.RevokedCount()
+
+	var reasons []string
+	if cfg.MaxRemovedUsers > 0 && removedUsers > cfg.MaxRemovedUsers {
+		reasons = append(reasons, fmt.Sprintf("%d users removed (max %d)", removedUsers, cfg.MaxRemovedUsers))
+	}
+	if cfg.MaxRemovedRules > 0 && removedRules > cfg.MaxRemovedRules {
+		reasons = append(reasons, fmt.Sprintf("%d rules removed (max %d)", removedRules, cfg.MaxRemovedRules))
+	}
+	if limit := cfg.MaxRemovedPercent; limit != nil && *limit > 0 && *limit < 100 {
+		if pct := percent(removedUsers, oldUsers); pct > *limit {
+			reasons = append(reasons, fmt.Sprintf("%.1f%% of users removed (max %.1f%%)", pct, *limit))
+		}
+		if pct := percent(removedRules, oldRules); pct > *limit {
+			reasons = append(reasons, fmt.Sprintf("%.1f%% of rules removed (max %.1f%%)", pct, *limit))
+		}
+	}
+
+	if len(reasons) > 0 {
+		return &SafetyError{Reasons: reasons}
+	}
+	return nil
+}
+
+// countRules returns the number of users and user/network pairs in a ruleset
+func countRules(users []UserWithNetworks) (int, int) {
+	rules := 0
+	for _, user := range users {
+		rules += len(user.Networks)
+	}
+	return len(users), rules
+}
+
+func percent(part, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(part) * 100 / float64(total)
+}
@@ -0,0 +1,254 @@
+package firewall
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+
+	"github.com/tldr-it-stepankutaj/openvpn-client/internal/api"
+)
+
+// maxCommentLength limits usernames and group names written as comments
+const maxCommentLength = 64
+
+// Rejection describes an API value that was not emitted into the rules
+type Rejection struct {
+	Username string `json:"username,omitempty"`
+	Group    string `json:"group,omitempty"`
+	Value    string `json:"value"`
+	Reason   string `json:"reason"`
+}
+
+func (r Rejection) String() string {
+	owner := r.Username
+	if r.Group != "" {
+		owner = "group " + r.Group
+	}
+	return fmt.Sprintf("%s: %q: %s", owner, r.Value, r.Reason)
+}
+
+// SanitizeUsers validates and canonicalises user addresses and networks.
+// Users with an invalid or duplicate username or an invalid VPN IP are
+// dropped, invalid networks are removed and users left without networks are
+// dropped. Usernames are never rewritten: they tie the rules back to users
+// when diffing. Every dropped value is reported as a Rejection.
+func SanitizeUsers(users []UserWithNetworks) ([]UserWithNetworks, []Rejection) {
+	var rejections []Rejection
+	result := make([]UserWithNetworks, 0, len(users))
+
+	count := make(map[string]int, len(users))
+	for _, user := range users {
+		count[user.Username]++
+	}
+
+	for _, user := range users {
+		name := user.Username
+		if err := ValidateUsername(name); err != nil {
+			rejections = append(rejections, Rejection{Value: name, Reason: err.Error()})
+			continue
+		}
+		if count[name] > 1 {
+			rejections = append(rejections, Rejection{Username: name, Value: name, Reason: "duplicate username"})
+			continue
+		}
+
+		vpnIP, err := CanonicalAddr(user.VpnIP)
+		if err != nil {
+			rejections = append(rejections, Rejection{Username: name, Value: user.VpnIP, Reason: err.Error()})
+			continue
+		}
+
+		networks, schedules, rejected := sanitizeNetworks(user.Networks, user.Schedules)
+		for _, r := range rejected {
+			r.Username = name
+			rejections = append(rejections, r)
+		}
+		if len(networks) == 0 {
+			continue
+		}
+
+		result = append(result, UserWithNetworks{
+			Username:  name,
+			VpnIP:     vpnIP,
+			Networks:  networks,
+			ValidFrom: user.ValidFrom,
+			ValidTo:   user.ValidTo,
+			Schedules: schedules,
+		})
+	}
+
+	return result, rejections
+}
+
+// SanitizeGroups validates and canonicalises group members and networks in
+// the same way as SanitizeUsers. Groups with an invalid or duplicate name
+// and members whose username is invalid or used with different addresses
+// are dropped, as are groups left without members or networks.
+func SanitizeGroups(groups []GroupWithMembers) ([]GroupWithMembers, []Rejection) {
+	var rejections []Rejection
+	result := make([]GroupWithMembers, 0, len(groups))
+
+	count := make(map[string]int, len(groups))
+	addrs := make(map[string]map[string]bool) // username -> VPN IPs
+	for _, group := range groups {
+		count[group.Name]++
+		for _, member := range group.Members {
+			if addrs[member.Username] == nil {
+				addrs[member.Username] = make(map[string]bool)
+			}
+			addrs[member.Username][member.VpnIP] = true
+		}
+	}
+
+	for _, group := range groups {
+		name := group.Name
+		if err := ValidateGroupName(name); err != nil {
+			rejections = append(rejections, Rejection{Value: name, Reason: err.Error()})
+			continue
+		}
+		if count[name] > 1 {
+			rejections = append(rejections, Rejection{Group: name, Value: name, Reason: "duplicate group name"})
+			continue
+		}
+
+		members := make([]GroupMember, 0, len(group.Members))
+		for _, member := range group.Members {
+			username := member.Username
+			if err := ValidateUsername(username); err != nil {
+				rejections = append(rejections, Rejection{Group: name, Value: username, Reason: err.Error()})
+				continue
+			}
+			if len(addrs[username]) > 1 {
+				rejections = append(rejections, Rejection{Username: username, Group: name, Value: username, Reason: "duplicate username"})
+				continue
+			}
+			vpnIP, err := CanonicalAddr(member.VpnIP)
+			if err != nil {
+				rejections = append(rejections, Rejection{Username: username, Group: name, Value: member.VpnIP, Reason: err.Error()})
+				continue
+			}
+			members = append(members, GroupMember{Username: username, VpnIP: vpnIP})
+		}
+
+		networks, schedules, rejected := sanitizeNetworks(group.Networks, group.Schedules)
+		for _, r := range rejected {
+			r.Group = name
+			rejections = append(rejections, r)
+		}
+
+		if len(members) == 0 || len(networks) == 0 {
+			continue
+		}
+
+		result = append(result, GroupWithMembers{
+			Name:      name,
+			Members:   members,
+			Networks:  networks,
+			Schedules: schedules,
+		})
+	}
+
+	return result, rejections
+}
+
+// CanonicalAddr validates a single IPv4 address and returns its canonical form
+func CanonicalAddr(s string) (string, error) {
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return "", fmt.Errorf("invalid IP address")
+	}
+	addr = addr.Unmap()
+	if !addr.Is4() {
+		return "", fmt.Errorf("only IPv4 addresses are supported")
+	}
+	return addr.String(), nil
+}
+
+// CanonicalPrefix validates an IPv4 network in CIDR notation (or a single
+// address) and returns its canonical, masked form
+func CanonicalPrefix(s string) (string, error) {
+	if !strings.Contains(s, "/") {
+		return CanonicalAddr(s)
+	}
+
+	prefix, err := netip.ParsePrefix(s)
+	if err != nil {
+		return "", fmt.Errorf("invalid CIDR")
+	}
+	if !prefix.Addr().Unmap().Is4() || prefix.Addr().Is4In6() {
+		return "", fmt.Errorf("only IPv4 networks are supported")
+	}
+	return prefix.Masked().String(), nil
+}
+
+// ValidateUsername checks that a username can be written as a rule comment
+// as is: 1 to maxCommentLength letters, digits and ".-_@+"
+func ValidateUsername(s string) error {
+	if err := validateName(s, ".-_@+", maxCommentLength); err != nil {
+		return fmt.Errorf("invalid username: %w", err)
+	}
+	return nil
+}
+
+// ValidateGroupName checks that a group name can be written as a rule
+// comment as is. Group names may also contain spaces and ":" (used by the
+// "user:<username>" pseudo-groups).
+func ValidateGroupName(s string) error {
+	if err := validateName(s, ".-_@+: ", maxCommentLength+len(userGroupPrefix)); err != nil {
+		return fmt.Errorf("invalid group name: %w", err)
+	}
+	return nil
+}
+
+// validateName accepts 1 to maxLen ASCII letters, digits and the given
+// punctuation. Names are rejected rather than rewritten, so two API names
+// can never end up with the same comment.
+func validateName(s, punct string, maxLen int) error {
+	switch {
+	case s == "":
+		return fmt.Errorf("empty")
+	case len(s) > maxLen:
+		return fmt.Errorf("longer than %d characters", maxLen)
+	case strings.TrimSpace(s) != s || strings.Contains(s, "  "):
+		return fmt.Errorf("leading, trailing or repeated spaces")
+	}
+	for _, r := range s {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		case strings.ContainsRune(punct, r):
+		default:
+			return fmt.Errorf("character %q not allowed", r)
+		}
+	}
+	return nil
+}
+
+// sanitizeNetworks canonicalises networks and the keys of their schedules,
+// dropping invalid entries, networks with invalid schedules and duplicates
+func sanitizeNetworks(networks []string, schedules map[string]*api.Schedule) ([]string, map[string]*api.Schedule, []Rejection) {
+	var rejections []Rejection
+	result := make([]string, 0, len(networks))
+	canonicalSchedules := make(map[string]*api.Schedule)
+	seen := make(map[string]bool, len(networks))
+
+	for _, network := range networks {
+		canonical, err := CanonicalPrefix(network)
+		if err != nil {
+			rejections = append(rejections, Rejection{Value: network, Reason: err.Error()})
+			continue
+		}
+		if schedule := schedules[network]; schedule != nil {
+			if err := schedule.Validate(); err != nil {
+				rejections = append(rejections, Rejection{Value: network, Reason: err.Error()})
+				continue
+			}
+			canonicalSchedules[canonical] = schedule
+		}
+		if !seen[canonical] {
+			seen[canonical] = true
+			result = append(result, canonical)
+		}
+	}
+
+	return result, canonicalSchedules, rejections
+}
@@ -0,0 +1,107 @@
+package firewall
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestSanitizeUsersRejectsInsteadOfRenaming(t *testing.T) {
+	users := []UserWithNetworks{
+		{Username: "a b", VpnIP: "10.8.0.2", Networks: []string{"10.1.0.0/24"}},
+		{Username: "a_b", VpnIP: "10.8.0.3", Networks: []string{"10.2.0.0/24"}},
+		{Username: "dup", VpnIP: "10.8.0.4", Networks: []string{"10.3.0.0/24"}},
+		{Username: "dup", VpnIP: "10.8.0.5", Networks: []string{"10.4.0.0/24"}},
+		{Username: "", VpnIP: "10.8.0.6", Networks: []string{"10.5.0.0/24"}},
+	}
+
+	result, rejections := SanitizeUsers(users)
+	if len(result) != 1 || result[0].Username != "a_b" {
+		t.Errorf("got %+v, want only a_b", result)
+	}
+	if len(rejections) != 4 {
+		t.Errorf("got %d rejections, want 4: %v", len(rejections), rejections)
+	}
+}
+
+func TestSanitizeGroupsRejectsCollisions(t *testing.T) {
+	groups := []GroupWithMembers{
+		{Name: "Dev Team", Members: []GroupMember{{Username: "alice", VpnIP: "10.8.0.2"}}, Networks: []string{"10.1.0.0/24"}},
+		{Name: "ops", Members: []GroupMember{{Username: "bob", VpnIP: "10.8.0.3"}}, Networks: []string{"10.2.0.0/24"}},
+		{Name: "ops", Members: []GroupMember{{Username: "carol", VpnIP: "10.8.0.4"}}, Networks: []string{"10.3.0.0/24"}},
+		{Name: "sec", Members: []GroupMember{{Username: "alice", VpnIP: "10.8.0.9"}}, Networks: []string{"10.4.0.0/24"}},
+		{Name: "bad\nname", Members: []GroupMember{{Username: "dave", VpnIP: "10.8.0.5"}}, Networks: []string{"10.5.0.0/24"}},
+	}
+
+	result, rejections := SanitizeGroups(groups)
+	if len(result) != 0 {
+		t.Errorf("got %+v, want no groups", result)
+	}
+	if len(rejections) != 5 {
+		t.Errorf("got %d rejections, want 5: %v", len(rejections), rejections)
+	}
+}
+
+// fuzzSeeds adds inputs (username, group name, VPN IP, CIDR) that try to
+// break out of comments and rules
+func fuzzSeeds(f *testing.F) {
+	f.Add("alice", "dev", "10.8.0.2", "10.0.0.0/8")
+	f.Add("a b", "Dev Team", "10.8.0.2", "192.168.1.5/24")
+	f.Add("eve\naccept", "g\n-A VPN_USERS -j ACCEPT", "10.8.0.2", "10.0.0.0/8")
+	f.Add("eve\" accept #", "g\"", "10.8.0.2 ip daddr 0.0.0.0/0", "10.0.0.0/8 } accept\nip saddr 0.0.0.0/0 ip daddr {")
+	f.Add("eve\r", "g\x00", "10.8.0.2\n", "10.0.0.0/8,0.0.0.0/0")
+	f.Add("ünïcode", "user:alice", "::ffff:10.8.0.2", "::/0")
+	f.Add(strings.Repeat("x", 100), "x", "10.8.0.256", "10.0.0.0/33")
+}
+
+// checkRules fails unless every line of rules matches one of patterns and
+// no line contains a quote
+func checkRules(t *testing.T, rules string, patterns ...*regexp.Regexp) {
+	t.Helper()
+
+	for _, line := range strings.Split(rules, "\n") {
+		if strings.ContainsAny(line, "\"'\r\x00") {
+			t.Fatalf("quote or control character in line %q:\n%s", line, rules)
+		}
+		matched := line == ""
+		for _, p := range patterns {
+			matched = matched || p.MatchString(line)
+		}
+		if !matched {
+			t.Fatalf("unexpected line %q:\n%s", line, rules)
+		}
+	}
+}
+
+// countLines returns the number of lines of rules matching p
+func countLines(rules string, p *regexp.Regexp) int {
+	n := 0
+	for _, line := range strings.Split(rules, "\n") {
+		if p.MatchString(line) {
+			n++
+		}
+	}
+	return n
+}
+
+// fuzzAccepted reports whether the fuzzed user and group are valid and
+// must therefore produce rules
+func fuzzAccepted(username, group, vpnIP, cidr string) (user, grp bool) {
+	_, ipErr := CanonicalAddr(vpnIP)
+	_, cidrErr := CanonicalPrefix(cidr)
+	user = ValidateUsername(username) == nil && ipErr == nil && cidrErr == nil &&
+		username != "alice" // the fixed user of every input
+	grp = user && ValidateGroupName(group) == nil && group != "base"
+	return user, grp
+}
+
+var (
+	nameChars = `[A-Za-z0-9.\-_@+]{1,64}`
+	ipv4      = `\d{1,3}\.\d{1,3}\.\d{1,3}\.\d{1,3}`
+	cidr4     = ipv4 + `(/\d{1,2})?`
+
+	headerComment = regexp.MustCompile(`^# (Auto-generated VPN (user|group) rules \((nftables|iptables)\)|Do not edit manually - changes will be overwritten)$`)
+	userComment   = regexp.MustCompile(`^# ` + nameChars + `$`)
+	groupComment  = regexp.MustCompile(`^# group [A-Za-z0-9.\-_@+: ]{1,69}$`)
+	memberComment = regexp.MustCompile(`^# member ` + nameChars + ` ` + ipv4 + `$`)
+)
@@ -0,0 +1,97 @@
+package firewall
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/tldr-it-stepankutaj/openvpn-client/internal/api"
+)
+
+// scheduledNetworks are networks sharing a schedule
+type scheduledNetworks struct {
+	schedule *api.Schedule
+	networks []string
+}
+
+// splitScheduled separates networks that are always available from networks
+// with a schedule, grouping the latter by schedule
+func splitScheduled(networks []string, schedules map[string]*api.Schedule) ([]string, []scheduledNetworks) {
+	var always []string
+	byKey := make(map[string]*scheduledNetworks)
+
+	for _, network := range networks {
+		schedule := schedules[network]
+		if schedule == nil {
+			always = append(always, network)
+			continue
+		}
+		key := schedule.Key()
+		if byKey[key] == nil {
+			byKey[key] = &scheduledNetworks{schedule: schedule}
+		}
+		byKey[key].networks = append(byKey[key].networks, network)
+	}
+
+	scheduled := make([]scheduledNetworks, 0, len(byKey))
+	for _, key := range sortedKeys(byKey) {
+		scheduled = append(scheduled, *byKey[key])
+	}
+	return always, scheduled
+}
+
+// scheduleSlots returns the schedule's slots in loc at the current UTC
+// offsets. The rules only change at daylight saving switches, when the
+// next sync regenerates them. Invalid schedules have no slots, so their
+// networks are never allowed.
+func scheduleSlots(schedule *api.Schedule, loc *time.Location) []api.ScheduleSlot {
+	slots, err := schedule.Slots(loc, time.Now())
+	if err != nil {
+		return nil
+	}
+	return slots
+}
+
+// nftSlot formats a slot as nftables "meta day" and "meta hour" expressions.
+// nft reads the hours in the local time of the host when the rules are loaded.
+func nftSlot(slot api.ScheduleSlot) string {
+	days := make([]string, 0, len(slot.Days))
+	for _, day := range slot.Days {
+		days = append(days, fmt.Sprintf("%q", day.String()))
+	}
+	return fmt.Sprintf("meta day { %s } meta hour \"%s\"-\"%s\" ",
+		strings.Join(days, ", "), formatClock(slot.Start), formatClock(slot.End))
+}
+
+// iptSlot formats a slot as an iptables time match in the kernel's time
+// zone, which is set to the host's local zone at boot
+func iptSlot(slot api.ScheduleSlot) string {
+	days := make([]string, 0, len(slot.Days))
+	for _, day := range slot.Days {
+		days = append(days, day.String()[:3])
+	}
+	return fmt.Sprintf("-m time --timestart %s --timestop %s --weekdays %s --kerneltz ",
+		formatClock(slot.Start), formatClock(slot.End), strings.Join(days, ","))
+}
+
+// formatClock formats minutes since midnight; the end of the day is
+// written as 23:59:59 since both firewalls use inclusive ranges
+func formatClock(minutes int) string {
+	if minutes >= 24*60 {
+		return "23:59:59"
+	}
+	return fmt.Sprintf("%02d:%02d", minutes/60, minutes%60)
+}
+
+// mergeSchedule records the schedule of a network. Networks that are
+// available without a schedule from any source stay unscheduled.
+func mergeSchedule(schedules map[string]*api.Schedule, unscheduled map[string]bool, network string, schedule *api.Schedule) {
+	if schedule == nil || unscheduled[network] {
+		unscheduled[network] = true
+		delete(schedules, network)
+		return
+	}
+	if _, ok := schedules[network]; !ok {
+		schedules[network] = schedule
+	}
+}
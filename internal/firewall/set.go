@@ -0,0 +1,131 @@
+package firewall
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/tldr-it-stepankutaj/openvpn-client/internal/config"
+)
+
+// AddressSet manages membership of VPN client addresses in a kernel set
+// (nftables named set or ipset) referenced by the static firewall config
+type AddressSet interface {
+	// Add adds the address to the set; adding an existing address is not an error
+	Add(ctx context.Context, address string) error
+	// Remove removes the address from the set; removing a missing address is not an error
+	Remove(ctx context.Context, address string) error
+}
+
+// NewAddressSet creates an address set based on configuration.
+// Returns nil if no dynamic set is configured for the firewall type.
+func NewAddressSet(cfg *config.FirewallConfig) AddressSet {
+	switch cfg.Type {
+	case "iptables":
+		if cfg.IPTables.IPSet == "" {
+			return nil
+		}
+		return &ipSet{name: cfg.IPTables.IPSet}
+	default:
+		if cfg.NFTables.DynamicSet == "" {
+			return nil
+		}
+		return &nftSet{spec: strings.Fields(cfg.NFTables.DynamicSet)}
+	}
+}
+
+// ParseSetAddress parses an OpenVPN address (IP or iroute'd subnet) into a
+// canonical set element. Returns false for addresses that cannot be placed
+// in an IP set, such as MAC addresses in tap mode.
+func ParseSetAddress(address string) (string, bool) {
+	if strings.Contains(address, "/") {
+		prefix, err := netip.ParsePrefix(address)
+		if err != nil {
+			return "", false
+		}
+		return prefix.Masked().String(), true
+	}
+
+	addr, err := netip.ParseAddr(address)
+	if err != nil {
+		return "", false
+	}
+	return addr.String(), true
+}
+
+// BanAddress adds address to the nftables set "<family> <table> <set>" for
+// the given duration. The set must be declared with "flags timeout".
+func BanAddress(ctx context.Context, spec, address string, timeout time.Duration) error {
+	set := &nftSet{spec: strings.Fields(spec)}
+	_, err := set.run(ctx, "add", fmt.Sprintf("%s timeout %ds", address, int64(timeout/time.Second)))
+	return err
+}
+
+// nftSet manages an nftables named set
+type nftSet struct {
+	spec []string // family, table, set
+}
+
+func (s *nftSet) Add(ctx context.Context, address string) error {
+	_, err := s.run(ctx, "add", address)
+	return err
+}
+
+func (s *nftSet) Remove(ctx context.Context, address string) error {
+	output, err := s.run(ctx, "delete", address)
+	if err == nil || !strings.Contains(output, "No such file or directory") {
+		return err
+	}
+
+	// nft reports a missing element, set and table the same way; only a
+	// missing element means the address is already gone
+	if lerr := s.exists(ctx); lerr != nil {
+		return fmt.Errorf("nftables set %s not found: %w", strings.Join(s.spec, " "), lerr)
+	}
+	return nil
+}
+
+// exists checks that the table and set are present
+func (s *nftSet) exists(ctx context.Context) error {
+	args := append([]string{"-t", "list", "set"}, s.spec...) // terse: without elements
+	output, err := exec.CommandContext(ctx, "nft", args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+func (s *nftSet) run(ctx context.Context, op, address string) (string, error) {
+	args := append([]string{op, "element"}, s.spec...)
+	args = append(args, "{ "+address+" }")
+
+	output, err := exec.CommandContext(ctx, "nft", args...).CombinedOutput()
+	if err != nil {
+		return string(output), fmt.Errorf("nft %s element failed: %w: %s", op, err, strings.TrimSpace(string(output)))
+	}
+	return string(output), nil
+}
+
+// ipSet manages an ipset
+type ipSet struct {
+	name string
+}
+
+func (s *ipSet) Add(ctx context.Context, address string) error {
+	return s.run(ctx, "add", address)
+}
+
+func (s *ipSet) Remove(ctx context.Context, address string) error {
+	return s.run(ctx, "del", address)
+}
+
+func (s *ipSet) run(ctx context.Context, op, address string) error {
+	output, err := exec.CommandContext(ctx, "ipset", "-exist", op, s.name, address).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("ipset %s failed: %w: %s", op, err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
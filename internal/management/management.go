@@ -0,0 +1,154 @@
+package management
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/tldr-it-stepankutaj/openvpn-client/internal/config"
+)
+
+// Client sends commands to the OpenVPN management interface
+type Client struct {
+	network  string
+	address  string
+	password string
+	timeout  time.Duration
+}
+
+// NewClient creates a management client. Addresses starting with "unix:"
+// are Unix sockets, anything else is a TCP "host:port".
+func NewClient(cfg *config.ManagementConfig) *Client {
+	network, address := "tcp", cfg.Address
+	if path, ok := strings.CutPrefix(cfg.Address, "unix:"); ok {
+		network, address = "unix", path
+	}
+	return &Client{
+		network:  network,
+		address:  address,
+		password: cfg.Password,
+		timeout:  cfg.Timeout,
+	}
+}
+
+// Send queues commands without waiting for their responses.
+//
+// OpenVPN serves management connections from its event loop, which is
+// blocked while a non-deferred hook (e.g. client-connect) runs. The
+// commands are buffered in the socket and executed once the hook returns,
+// so hooks must never wait for a reply.
+func (c *Client) Send(ctx context.Context, commands ...string) error {
+	conn, err := c.send(ctx, commands)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// Run sends commands and returns their "SUCCESS:" / "ERROR:" replies in
+// order. It may only be used while OpenVPN's event loop is free, e.g. from
+// a deferred hook worker; see Send.
+func (c *Client) Run(ctx context.Context, commands ...string) ([]string, error) {
+	conn, err := c.send(ctx, commands)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	if err := conn.SetReadDeadline(time.Now().Add(c.timeout)); err != nil {
+		return nil, err
+	}
+
+	want := len(commands)
+	if c.password != "" {
+		want++
+	}
+	replies := make([]string, 0, want)
+	reader := bufio.NewReader(conn)
+	for len(replies) < want {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return nil, fmt.Errorf("failed to read management reply: %w", err)
+		}
+		// The password prompt is not terminated by a newline
+		line = strings.TrimSpace(strings.TrimPrefix(line, "ENTER PASSWORD:"))
+		if strings.HasPrefix(line, "SUCCESS:") || strings.HasPrefix(line, "ERROR:") {
+			replies = append(replies, line)
+		}
+		// Anything else is the banner or a real-time ">" notification
+	}
+
+	if c.password != "" {
+		if !strings.HasPrefix(replies[0], "SUCCESS:") {
+			return nil, fmt.Errorf("management password rejected: %s", replies[0])
+		}
+		replies = replies[1:]
+	}
+	return replies, nil
+}
+
+// send connects and writes the password, the commands and "quit"
+func (c *Client) send(ctx context.Context, commands []string) (net.Conn, error) {
+	var script strings.Builder
+	if c.password != "" {
+		script.WriteString(c.password + "\n")
+	}
+	for _, command := range commands {
+		if strings.ContainsAny(command, "\r\n") {
+			return nil, fmt.Errorf("invalid management command %q", command)
+		}
+		script.WriteString(command + "\n")
+	}
+	script.WriteString("quit\n")
+
+	dialer := net.Dialer{Timeout: c.timeout}
+	conn, err := dialer.DialContext(ctx, c.network, c.address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to management interface: %w", err)
+	}
+
+	if err := conn.SetWriteDeadline(time.Now().Add(c.timeout)); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	if _, err := conn.Write([]byte(script.String())); err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("failed to send management commands: %w", err)
+	}
+	return conn, nil
+}
+
+// Kill disconnects the clients with the given real addresses ("ip:port")
+// and waits for OpenVPN to confirm every kill. Like Run, it may only be
+// used while OpenVPN's event loop is free.
+func (c *Client) Kill(ctx context.Context, realAddresses ...string) error {
+	replies, err := c.Run(ctx, killCommands(realAddresses)...)
+	if err != nil {
+		return err
+	}
+	for i, reply := range replies {
+		if !strings.HasPrefix(reply, "SUCCESS:") {
+			return fmt.Errorf("kill %s: %s", realAddresses[i], reply)
+		}
+	}
+	return nil
+}
+
+// QueueKill queues kill commands for the given real addresses without
+// waiting for them; see Send
+func (c *Client) QueueKill(ctx context.Context, realAddresses ...string) error {
+	return c.Send(ctx, killCommands(realAddresses)...)
+}
+
+func killCommands(realAddresses []string) []string {
+	commands := make([]string, 0, len(realAddresses))
+	for _, address := range realAddresses {
+		commands = append(commands, "kill "+address)
+	}
+	return commands
+}
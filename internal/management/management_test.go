@@ -0,0 +1,83 @@
+package management
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tldr-it-stepankutaj/openvpn-client/internal/config"
+)
+
+// fakeServer answers like OpenVPN's management interface: a password prompt
+// without newline, the banner, a notification and reply for every command
+func fakeServer(t *testing.T, reply func(command string) string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "mgmt.sock")
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = ln.Close() })
+
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		r := bufio.NewReader(conn)
+		_, _ = conn.Write([]byte("ENTER PASSWORD:"))
+		if line, _ := r.ReadString('\n'); strings.TrimSpace(line) != "secret" {
+			_, _ = conn.Write([]byte("ERROR: bad password\r\n"))
+			return
+		}
+		_, _ = conn.Write([]byte("SUCCESS: password is correct\r\n>INFO:OpenVPN Management Interface\r\n"))
+		for {
+			line, err := r.ReadString('\n')
+			command := strings.TrimSpace(line)
+			if err != nil || command == "quit" {
+				return
+			}
+			_, _ = conn.Write([]byte(">CLIENT:ESTABLISHED,1\r\n" + reply(command) + "\r\n"))
+		}
+	}()
+
+	return "unix:" + path
+}
+
+func TestKill(t *testing.T) {
+	tests := []struct {
+		name    string
+		reply   func(string) string
+		wantErr bool
+	}{
+		{"confirmed", func(string) string { return "SUCCESS: common name 'alice' found, 1 client(s) killed" }, false},
+		{"not found", func(c string) string {
+			if strings.HasSuffix(c, ":2") {
+				return "ERROR: common name '10.0.0.1:2' not found"
+			}
+			return "SUCCESS: 1 client(s) killed"
+		}, true},
+		{"no reply", func(string) string { return "" }, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := NewClient(&config.ManagementConfig{
+				Address:  fakeServer(t, tt.reply),
+				Password: "secret",
+				Timeout:  200 * time.Millisecond,
+			})
+			err := client.Kill(context.Background(), "10.0.0.1:1", "10.0.0.1:2")
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Kill() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
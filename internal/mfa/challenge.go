@@ -0,0 +1,273 @@
+package mfa
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/tldr-it-stepankutaj/openvpn-client/internal/utils"
+)
+
+const (
+	staticPrefix    = "SCRV1:"
+	dynamicPrefix   = "CRV1::"
+	challengePrefix = "challenge-"
+	lockFile        = "challenges.lock"
+)
+
+// MaxPending is the number of unanswered challenges kept per username and
+// per source IP
+const MaxPending = 3
+
+var (
+	// ErrChallengeNotFound is returned for unknown, used or expired challenges
+	ErrChallengeNotFound = errors.New("challenge not found or expired")
+	// ErrChallengeMismatch is returned when a response comes from another
+	// user or source address than the challenge was issued to
+	ErrChallengeMismatch = errors.New("challenge response does not match the challenge")
+	// ErrTooManyChallenges is returned when the username or source address
+	// already has MaxPending unanswered challenges
+	ErrTooManyChallenges = errors.New("too many pending challenges")
+)
+
+// ParseStaticResponse decodes a static challenge password
+// "SCRV1:base64(password):base64(response)". Returns false if the password
+// does not use the static challenge encoding.
+func ParseStaticResponse(password string) (string, string, bool, error) {
+	encoded, ok := strings.CutPrefix(password, staticPrefix)
+	if !ok {
+		return "", "", false, nil
+	}
+
+	parts := strings.Split(encoded, ":")
+	if len(parts) != 2 {
+		return "", "", true, fmt.Errorf("invalid static challenge response")
+	}
+	pass, err := base64.StdEncoding.DecodeString(parts[0])
+	if err != nil {
+		return "", "", true, fmt.Errorf("invalid static challenge password: %w", err)
+	}
+	response, err := base64.StdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", "", true, fmt.Errorf("invalid static challenge response: %w", err)
+	}
+	return string(pass), string(response), true, nil
+}
+
+// ParseDynamicResponse parses a dynamic challenge response password
+// "CRV1::<state_id>::<response>". Returns false if the password is not a
+// dynamic challenge response.
+func ParseDynamicResponse(password string) (string, string, bool) {
+	rest, ok := strings.CutPrefix(password, dynamicPrefix)
+	if !ok {
+		return "", "", false
+	}
+	stateID, response, ok := strings.Cut(rest, "::")
+	if !ok {
+		return "", "", false
+	}
+	return stateID, response, true
+}
+
+// ChallengeReason formats the auth failure reason asking the client for a
+// dynamic challenge response
+func ChallengeReason(stateID, username, text string, echo bool) string {
+	flags := "R"
+	if echo {
+		flags += ",E"
+	}
+	return fmt.Sprintf("CRV1:%s:%s:%s:%s", flags, stateID,
+		base64.StdEncoding.EncodeToString([]byte(username)), text)
+}
+
+// challenge is a pending dynamic challenge stored on disk. The password is
+// encrypted with a key that is only part of the state ID sent to the client.
+type challenge struct {
+	Username   string    `json:"username"`
+	SourceIP   string    `json:"source_ip"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	Nonce      []byte    `json:"nonce"`
+	Ciphertext []byte    `json:"ciphertext"`
+}
+
+// Store keeps pending dynamic challenges in the session directory
+type Store struct {
+	dir string
+	ttl time.Duration
+}
+
+// NewStore creates a challenge store; challenges expire after ttl
+func NewStore(dir string, ttl time.Duration) *Store {
+	return &Store{dir: dir, ttl: ttl}
+}
+
+// Create stores a challenge for the login and returns its state ID. The
+// caller must have verified the password. At most MaxPending challenges
+// are kept per username and per source IP.
+func (s *Store) Create(username, sourceIP, password string) (string, error) {
+	unlock, err := s.lock()
+	if err != nil {
+		return "", err
+	}
+	defer unlock()
+
+	byUser, byIP := s.pending(username, sourceIP)
+	if byUser >= MaxPending || (sourceIP != "" && byIP >= MaxPending) {
+		return "", ErrTooManyChallenges
+	}
+
+	id := make([]byte, 16)
+	key := make([]byte, 32)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	c := challenge{
+		Username:   username,
+		SourceIP:   sourceIP,
+		ExpiresAt:  time.Now().Add(s.ttl),
+		Nonce:      nonce,
+		Ciphertext: gcm.Seal(nil, nonce, []byte(password), additionalData(username, sourceIP)),
+	}
+	data, err := json.Marshal(&c)
+	if err != nil {
+		return "", err
+	}
+
+	name := hex.EncodeToString(id)
+	if err := utils.WriteFileAtomic(s.path(name), data, 0600); err != nil {
+		return "", err
+	}
+	return name + "." + base64.RawURLEncoding.EncodeToString(key), nil
+}
+
+// Consume removes the challenge and returns the password of the original
+// login. Each challenge can be answered once.
+func (s *Store) Consume(stateID, username, sourceIP string) (string, error) {
+	name, encodedKey, ok := strings.Cut(stateID, ".")
+	if !ok || !isHex(name) {
+		return "", ErrChallengeNotFound
+	}
+
+	path := s.path(name)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return "", ErrChallengeNotFound
+		}
+		return "", err
+	}
+	if err := os.Remove(path); err != nil {
+		return "", err
+	}
+
+	var c challenge
+	if err := json.Unmarshal(data, &c); err != nil {
+		return "", fmt.Errorf("invalid challenge file: %w", err)
+	}
+	if time.Now().After(c.ExpiresAt) {
+		return "", ErrChallengeNotFound
+	}
+	if c.Username != username || c.SourceIP != sourceIP {
+		return "", ErrChallengeMismatch
+	}
+
+	key, err := base64.RawURLEncoding.DecodeString(encodedKey)
+	if err != nil {
+		return "", ErrChallengeMismatch
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", ErrChallengeMismatch
+	}
+	password, err := gcm.Open(nil, c.Nonce, c.Ciphertext, additionalData(username, sourceIP))
+	if err != nil {
+		return "", ErrChallengeMismatch
+	}
+	return string(password), nil
+}
+
+// pending deletes challenges that were never answered and counts the
+// remaining challenges of username and of sourceIP
+func (s *Store) pending(username, sourceIP string) (byUser, byIP int) {
+	paths, _ := filepath.Glob(filepath.Join(s.dir, challengePrefix+"*"))
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		var c challenge
+		if json.Unmarshal(data, &c) != nil || time.Now().After(c.ExpiresAt) {
+			_ = os.Remove(path)
+			continue
+		}
+		if c.Username == username {
+			byUser++
+		}
+		if c.SourceIP == sourceIP {
+			byIP++
+		}
+	}
+	return byUser, byIP
+}
+
+// lock takes an exclusive lock on the store, so concurrent logins cannot
+// exceed MaxPending
+func (s *Store) lock() (func(), error) {
+	f, err := os.OpenFile(filepath.Join(s.dir, lockFile), os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open challenge lock: %w", err)
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		_ = f.Close()
+		return nil, fmt.Errorf("failed to lock challenges: %w", err)
+	}
+	return func() {
+		_ = syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+		_ = f.Close()
+	}, nil
+}
+
+func (s *Store) path(name string) string {
+	return filepath.Join(s.dir, challengePrefix+name)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// additionalData binds the encrypted password to the login it belongs to
+func additionalData(username, sourceIP string) []byte {
+	return []byte(username + "\x00" + sourceIP)
+}
+
+func isHex(s string) bool {
+	_, err := hex.DecodeString(s)
+	return err == nil && s != ""
+}
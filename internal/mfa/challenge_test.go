@@ -0,0 +1,66 @@
+package mfa
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestChallengeRoundTrip(t *testing.T) {
+	store := NewStore(t.TempDir(), time.Minute)
+
+	stateID, err := store.Create("alice", "192.0.2.1", "secret")
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if _, err := store.Consume(stateID, "alice", "192.0.2.2"); !errors.Is(err, ErrChallengeMismatch) {
+		t.Errorf("Consume from another address: got %v, want ErrChallengeMismatch", err)
+	}
+
+	stateID, err = store.Create("alice", "192.0.2.1", "secret")
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	password, err := store.Consume(stateID, "alice", "192.0.2.1")
+	if err != nil || password != "secret" {
+		t.Fatalf("Consume = %q, %v, want secret", password, err)
+	}
+	if _, err := store.Consume(stateID, "alice", "192.0.2.1"); !errors.Is(err, ErrChallengeNotFound) {
+		t.Errorf("second Consume: got %v, want ErrChallengeNotFound", err)
+	}
+}
+
+func TestCreateCapsPendingChallenges(t *testing.T) {
+	tests := []struct {
+		name     string
+		username func(i int) string
+		sourceIP func(i int) string
+	}{
+		{"per user", func(int) string { return "alice" }, func(i int) string { return fmt.Sprintf("192.0.2.%d", i+1) }},
+		{"per source IP", func(i int) string { return fmt.Sprintf("user%d", i) }, func(int) string { return "192.0.2.1" }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := NewStore(t.TempDir(), time.Minute)
+			for i := 0; i < MaxPending; i++ {
+				if _, err := store.Create(tt.username(i), tt.sourceIP(i), "secret"); err != nil {
+					t.Fatalf("Create %d: %v", i, err)
+				}
+			}
+			if _, err := store.Create(tt.username(MaxPending), tt.sourceIP(MaxPending), "secret"); !errors.Is(err, ErrTooManyChallenges) {
+				t.Errorf("got %v, want ErrTooManyChallenges", err)
+			}
+		})
+	}
+}
+
+func TestCreateRemovesExpired(t *testing.T) {
+	store := NewStore(t.TempDir(), -time.Second)
+	for i := 0; i < MaxPending+1; i++ {
+		if _, err := store.Create("alice", "192.0.2.1", "secret"); err != nil {
+			t.Fatalf("Create %d: %v", i, err)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package pki
+
+import (
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// CA is a certificate authority with its signing key
+type CA struct {
+	Cert *x509.Certificate
+	Key  crypto.Signer
+}
+
+// LoadCA reads a PEM CA certificate and its unencrypted PEM private key
+// (PKCS#8, SEC 1 EC or PKCS#1 RSA)
+func LoadCA(certPath, keyPath string) (*CA, error) {
+	certPEM, err := os.ReadFile(certPath)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(certPEM)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, fmt.Errorf("no certificate in %s", certPath)
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid CA certificate %s: %w", certPath, err)
+	}
+	if !cert.IsCA {
+		return nil, fmt.Errorf("%s is not a CA certificate", certPath)
+	}
+
+	keyPEM, err := os.ReadFile(keyPath)
+	if err != nil {
+		return nil, err
+	}
+	key, err := parsePrivateKey(keyPEM)
+	if err != nil {
+		return nil, fmt.Errorf("invalid CA key %s: %w", keyPath, err)
+	}
+
+	pub, ok := key.Public().(interface{ Equal(crypto.PublicKey) bool })
+	if !ok || !pub.Equal(cert.PublicKey) {
+		return nil, fmt.Errorf("CA key %s does not match certificate %s", keyPath, certPath)
+	}
+	return &CA{Cert: cert, Key: key}, nil
+}
+
+func parsePrivateKey(data []byte) (crypto.Signer, error) {
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return nil, errors.New("no private key found")
+		}
+
+		var key any
+		var err error
+		switch block.Type {
+		case "PRIVATE KEY":
+			key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+		case "EC PRIVATE KEY":
+			key, err = x509.ParseECPrivateKey(block.Bytes)
+		case "RSA PRIVATE KEY":
+			key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+		case "ENCRYPTED PRIVATE KEY":
+			return nil, errors.New("encrypted keys are not supported")
+		default:
+			// e.g. "EC PARAMETERS" written by openssl ecparam
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		signer, ok := key.(crypto.Signer)
+		if !ok {
+			return nil, fmt.Errorf("unsupported key type %T", key)
+		}
+		return signer, nil
+	}
+}
+
+// maxCNLength is the longest common name allowed in a certificate
+const maxCNLength = 64
+
+// ValidCN accepts common names that are safe as easy-rsa file names and in
+// the index: 1 to 64 letters, digits and ".-_@+", not starting with "."
+func ValidCN(cn string) error {
+	if cn == "" || len(cn) > maxCNLength {
+		return fmt.Errorf("invalid common name length")
+	}
+	if strings.HasPrefix(cn, ".") {
+		return fmt.Errorf("common name must not start with '.'")
+	}
+	for _, r := range cn {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		case strings.ContainsRune(".-_@+", r):
+		default:
+			return fmt.Errorf("invalid character %q in common name", r)
+		}
+	}
+	return nil
+}
+
+// CertPath returns where the certificate of cn is kept in an easy-rsa
+// directory (<dir>/issued/<cn>.crt)
+func CertPath(dir, cn string) (string, error) {
+	if err := ValidCN(cn); err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "issued", cn+".crt"), nil
+}
+
+// KeyPath returns where the private key of cn is kept in an easy-rsa
+// directory (<dir>/private/<cn>.key)
+func KeyPath(dir, cn string) (string, error) {
+	if err := ValidCN(cn); err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "private", cn+".key"), nil
+}
@@ -0,0 +1,52 @@
+package pki
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"testing"
+)
+
+func TestValidCN(t *testing.T) {
+	tests := []struct {
+		cn      string
+		wantErr bool
+	}{
+		{"alice", false},
+		{"a.b-c_d@example.com+1", false},
+		{"", true},
+		{".hidden", true},
+		{"../ca", true},
+		{"a/b", true},
+		{"a b", true},
+		{string(make([]byte, maxCNLength+1)), true},
+	}
+
+	for _, tt := range tests {
+		if err := ValidCN(tt.cn); (err != nil) != tt.wantErr {
+			t.Errorf("ValidCN(%q) error = %v, wantErr %v", tt.cn, err, tt.wantErr)
+		}
+		if _, err := CertPath("/pki", tt.cn); (err != nil) != tt.wantErr {
+			t.Errorf("CertPath(%q) error = %v, wantErr %v", tt.cn, err, tt.wantErr)
+		}
+		if _, err := KeyPath("/pki", tt.cn); (err != nil) != tt.wantErr {
+			t.Errorf("KeyPath(%q) error = %v, wantErr %v", tt.cn, err, tt.wantErr)
+		}
+	}
+}
+
+func TestIndexAddRefusesInvalidCN(t *testing.T) {
+	idx := &Index{}
+	cert := &x509.Certificate{SerialNumber: big.NewInt(1), Subject: pkix.Name{CommonName: "alice/CN=admin"}}
+	if err := idx.Add(cert); err == nil {
+		t.Error("Add accepted an invalid common name")
+	}
+	if len(idx.Entries) != 0 {
+		t.Errorf("got %d entries, want 0", len(idx.Entries))
+	}
+
+	cert.Subject.CommonName = "alice"
+	if err := idx.Add(cert); err != nil || len(idx.Entries) != 1 {
+		t.Errorf("Add(alice) = %v with %d entries, want 1 entry", err, len(idx.Entries))
+	}
+}
@@ -0,0 +1,84 @@
+package pki
+
+import (
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"time"
+
+	"github.com/tldr-it-stepankutaj/openvpn-client/internal/utils"
+)
+
+// LoadCRL reads a PEM CRL. Returns nil without an error if the file does
+// not exist.
+func LoadCRL(path string) (*x509.RevocationList, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "X509 CRL" {
+		return nil, fmt.Errorf("no CRL in %s", path)
+	}
+	crl, err := x509.ParseRevocationList(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid CRL %s: %w", path, err)
+	}
+	return crl, nil
+}
+
+// CRLStale reports whether crl should be regenerated: it is missing or more
+// than half of its validity has passed
+func CRLStale(crl *x509.RevocationList, now time.Time) bool {
+	if crl == nil {
+		return true
+	}
+	half := crl.NextUpdate.Sub(crl.ThisUpdate) / 2
+	return !now.Before(crl.ThisUpdate.Add(half))
+}
+
+// CreateCRL signs a PEM CRL listing every revoked certificate in idx. The
+// CRL number is one more than prev's, or 1 without a previous CRL.
+func (ca *CA) CreateCRL(idx *Index, prev *x509.RevocationList, now time.Time, validity time.Duration) ([]byte, error) {
+	number := big.NewInt(1)
+	if prev != nil && prev.Number != nil {
+		number.Add(prev.Number, number)
+	}
+
+	template := &x509.RevocationList{
+		Number:     number,
+		ThisUpdate: now.UTC(),
+		NextUpdate: now.UTC().Add(validity),
+	}
+	for i := range idx.Entries {
+		entry := &idx.Entries[i]
+		if entry.Status != StatusRevoked {
+			continue
+		}
+		template.RevokedCertificateEntries = append(template.RevokedCertificateEntries, x509.RevocationListEntry{
+			SerialNumber:   entry.SerialNumber(),
+			RevocationTime: entry.RevokedAt,
+			ReasonCode:     entry.ReasonCode(),
+		})
+	}
+
+	der, err := x509.CreateRevocationList(rand.Reader, template, ca.Cert, ca.Key)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: der}), nil
+}
+
+// SaveCRL writes a PEM CRL atomically. It must stay world-readable: OpenVPN
+// reads crl-verify after dropping privileges.
+func SaveCRL(path string, data []byte) error {
+	return utils.WriteFileAtomic(path, data, 0644)
+}
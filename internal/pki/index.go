@@ -0,0 +1,231 @@
+package pki
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/tldr-it-stepankutaj/openvpn-client/internal/utils"
+)
+
+// Index entry status flags, as in OpenSSL's index.txt
+const (
+	StatusValid   = "V"
+	StatusRevoked = "R"
+	StatusExpired = "E"
+)
+
+// Revocation reasons (RFC 5280) in OpenSSL's index.txt spelling
+const (
+	ReasonUnspecified          = "unspecified"
+	ReasonKeyCompromise        = "keyCompromise"
+	ReasonAffiliationChanged   = "affiliationChanged"
+	ReasonSuperseded           = "superseded"
+	ReasonCessationOfOperation = "cessationOfOperation"
+)
+
+// reasonCodes maps index.txt reasons to CRL reason codes
+var reasonCodes = map[string]int{
+	ReasonUnspecified:          0,
+	ReasonKeyCompromise:        1,
+	"CACompromise":             2,
+	ReasonAffiliationChanged:   3,
+	ReasonSuperseded:           4,
+	ReasonCessationOfOperation: 5,
+	"certificateHold":          6,
+	"removeFromCRL":            8,
+}
+
+const (
+	utcTimeLayout         = "060102150405Z"
+	generalizedTimeLayout = "20060102150405Z"
+)
+
+// Entry is one certificate in the index
+type Entry struct {
+	Status    string
+	Expires   time.Time
+	RevokedAt time.Time // zero unless revoked
+	Reason    string    // revocation reason, empty = unspecified
+	Serial    string    // upper-case hex as written by OpenSSL
+	File      string    // certificate file name, usually "unknown"
+	Subject   string    // OpenSSL one-line DN, e.g. "/CN=alice"
+}
+
+// Index is an OpenSSL/easy-rsa index.txt: the database of issued certificates
+type Index struct {
+	Entries []Entry
+}
+
+// LoadIndex reads an index.txt file
+func LoadIndex(path string) (*Index, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseIndex(data)
+}
+
+// ParseIndex parses index.txt content
+func ParseIndex(data []byte) (*Index, error) {
+	idx := &Index{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		entry, err := parseEntry(line)
+		if err != nil {
+			return nil, fmt.Errorf("index line %d: %w", lineNo, err)
+		}
+		idx.Entries = append(idx.Entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return idx, nil
+}
+
+func parseEntry(line string) (Entry, error) {
+	fields := strings.Split(line, "\t")
+	if len(fields) != 6 {
+		return Entry{}, fmt.Errorf("expected 6 tab-separated fields, got %d", len(fields))
+	}
+
+	entry := Entry{
+		Status:  fields[0],
+		Serial:  strings.ToUpper(fields[3]),
+		File:    fields[4],
+		Subject: fields[5],
+	}
+	switch entry.Status {
+	case StatusValid, StatusRevoked, StatusExpired:
+	default:
+		return Entry{}, fmt.Errorf("unknown status %q", entry.Status)
+	}
+
+	var err error
+	if entry.Expires, err = parseTime(fields[1]); err != nil {
+		return Entry{}, fmt.Errorf("invalid expiry: %w", err)
+	}
+	if _, ok := new(big.Int).SetString(entry.Serial, 16); !ok {
+		return Entry{}, fmt.Errorf("invalid serial %q", fields[3])
+	}
+
+	if entry.Status == StatusRevoked {
+		// "<time>[,<reason>[,<extra>]]"
+		revoked, reason, _ := strings.Cut(fields[2], ",")
+		if entry.RevokedAt, err = parseTime(revoked); err != nil {
+			return Entry{}, fmt.Errorf("invalid revocation time: %w", err)
+		}
+		reason, _, _ = strings.Cut(reason, ",")
+		if _, ok := reasonCodes[reason]; reason != "" && !ok {
+			return Entry{}, fmt.Errorf("unknown revocation reason %q", reason)
+		}
+		entry.Reason = reason
+	}
+	return entry, nil
+}
+
+// Bytes returns the index in index.txt format
+func (idx *Index) Bytes() []byte {
+	var out bytes.Buffer
+	for _, e := range idx.Entries {
+		revoked := ""
+		if e.Status == StatusRevoked {
+			revoked = formatTime(e.RevokedAt)
+			if e.Reason != "" {
+				revoked += "," + e.Reason
+			}
+		}
+		fmt.Fprintf(&out, "%s\t%s\t%s\t%s\t%s\t%s\n", e.Status, formatTime(e.Expires), revoked, e.Serial, e.File, e.Subject)
+	}
+	return out.Bytes()
+}
+
+// Save writes the index atomically, keeping the mode of an existing file
+func (idx *Index) Save(path string) error {
+	perm := os.FileMode(0600)
+	if info, err := os.Stat(path); err == nil {
+		perm = info.Mode().Perm()
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return utils.WriteFileAtomic(path, idx.Bytes(), perm)
+}
+
+// LockIndex takes an exclusive lock on <path>.lock, serialising index
+// updates by openvpn-crl, openvpn-pki and openvpn-profile. The returned
+// function releases the lock.
+func LockIndex(path string) (func(), error) {
+	f, err := os.OpenFile(path+".lock", os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open index lock: %w", err)
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		_ = f.Close()
+		return nil, fmt.Errorf("failed to lock index: %w", err)
+	}
+	return func() {
+		_ = syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+		_ = f.Close()
+	}, nil
+}
+
+// CommonName returns the CN of the entry's subject, or "" if it has none
+func (e *Entry) CommonName() string {
+	for _, rdn := range strings.Split(e.Subject, "/") {
+		if name, value, ok := strings.Cut(rdn, "="); ok && name == "CN" {
+			return value
+		}
+	}
+	return ""
+}
+
+// SerialNumber returns the serial as an integer
+func (e *Entry) SerialNumber() *big.Int {
+	serial, _ := new(big.Int).SetString(e.Serial, 16)
+	return serial
+}
+
+// Revoke marks the entry revoked at t for reason
+func (e *Entry) Revoke(t time.Time, reason string) {
+	e.Status = StatusRevoked
+	e.RevokedAt = t.UTC().Truncate(time.Second)
+	e.Reason = reason
+}
+
+// ReasonCode returns the CRL reason code of a revoked entry
+func (e *Entry) ReasonCode() int {
+	return reasonCodes[e.Reason]
+}
+
+func parseTime(s string) (time.Time, error) {
+	if len(s) == len(generalizedTimeLayout) {
+		return time.Parse(generalizedTimeLayout, s)
+	}
+	t, err := time.Parse(utcTimeLayout, s)
+	if err != nil {
+		return time.Time{}, err
+	}
+	// RFC 5280: two-digit years 50-99 are 19xx
+	if t.Year() >= 2050 {
+		t = t.AddDate(-100, 0, 0)
+	}
+	return t, nil
+}
+
+func formatTime(t time.Time) string {
+	t = t.UTC()
+	if t.Year() >= 1950 && t.Year() < 2050 {
+		return t.Format(utcTimeLayout)
+	}
+	return t.Format(generalizedTimeLayout)
+}
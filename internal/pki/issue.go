@@ -0,0 +1,276 @@
+package pki
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/tldr-it-stepankutaj/openvpn-client/internal/config"
+	"github.com/tldr-it-stepankutaj/openvpn-client/internal/utils"
+)
+
+// Key types of issued certificates
+const (
+	KeyTypeECDSA   = "ecdsa" // P-256
+	KeyTypeEd25519 = "ed25519"
+)
+
+// Issuance actions
+const (
+	ActionKeep    = ""        // the current certificate is valid long enough
+	ActionIssue   = "issue"   // the user has no usable certificate
+	ActionRenew   = "renew"   // the current certificate expires within renew_before
+	ActionReissue = "reissue" // forced; the current certificate is revoked as superseded
+)
+
+// Issued is a newly issued client certificate with its key
+type Issued struct {
+	Cert    *x509.Certificate
+	CertPEM []byte
+	KeyPEM  []byte
+}
+
+// Issue creates a client certificate for cn with a new key of keyType. The
+// validity is capped at the CA's own expiry.
+func (ca *CA) Issue(cn, keyType string, validity time.Duration, now time.Time) (*Issued, error) {
+	var key crypto.Signer
+	var err error
+	switch keyType {
+	case KeyTypeECDSA:
+		key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case KeyTypeEd25519:
+		_, key, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", keyType)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Random 128-bit serials, like easy-rsa
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+
+	notAfter := now.Add(validity)
+	if notAfter.After(ca.Cert.NotAfter) {
+		notAfter = ca.Cert.NotAfter
+	}
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: cn},
+		NotBefore:             now.Add(-time.Minute).UTC(),
+		NotAfter:              notAfter.UTC(),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.Cert, key.Public(), ca.Key)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Issued{
+		Cert:    cert,
+		CertPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		KeyPEM:  pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}),
+	}, nil
+}
+
+// Add records cert in the index as valid. Certificates whose common name
+// fails ValidCN are refused.
+func (idx *Index) Add(cert *x509.Certificate) error {
+	if err := ValidCN(cert.Subject.CommonName); err != nil {
+		return err
+	}
+	idx.Entries = append(idx.Entries, Entry{
+		Status:  StatusValid,
+		Expires: cert.NotAfter.UTC(),
+		Serial:  strings.ToUpper(cert.SerialNumber.Text(16)),
+		File:    "unknown",
+		Subject: "/CN=" + cert.Subject.CommonName,
+	})
+	return nil
+}
+
+// Find returns the entry with the given serial, or nil
+func (idx *Index) Find(serial *big.Int) *Entry {
+	for i := range idx.Entries {
+		if idx.Entries[i].SerialNumber().Cmp(serial) == 0 {
+			return &idx.Entries[i]
+		}
+	}
+	return nil
+}
+
+// Issuer issues and renews client certificates from a CA on disk. Keys and
+// certificates are stored in the easy-rsa layout (issued/, private/,
+// certs_by_serial/) and recorded in the index, which openvpn-crl and
+// openvpn-profile read.
+type Issuer struct {
+	cfg *config.PKIConfig
+	idx *Index
+	ca  *CA // loaded on first issue
+}
+
+// NewIssuer creates an issuer for the index loaded from cfg.IndexFile
+func NewIssuer(cfg *config.PKIConfig, idx *Index) *Issuer {
+	return &Issuer{cfg: cfg, idx: idx}
+}
+
+// Plan returns what Ensure would do for cn, and the index entry of the
+// current certificate (nil if there is none). Invalid common names are
+// refused (see ValidCN).
+func (is *Issuer) Plan(cn string, now time.Time, force bool) (string, *Entry, error) {
+	path, err := CertPath(is.cfg.Dir, cn)
+	if err != nil {
+		return "", nil, err
+	}
+	cert, err := loadCert(path)
+	if err != nil {
+		return ActionIssue, nil, nil
+	}
+	// The stored certificate must be the one the index knows as valid
+	current := is.idx.Find(cert.SerialNumber)
+	if current == nil || current.Status != StatusValid || !cert.NotAfter.After(now) {
+		return ActionIssue, nil, nil
+	}
+
+	switch {
+	case force:
+		return ActionReissue, current, nil
+	case cert.NotAfter.Sub(now) <= is.cfg.RenewBefore:
+		return ActionRenew, current, nil
+	}
+	return ActionKeep, current, nil
+}
+
+// Ensure issues a certificate for cn if it has none, renews one that
+// expires within renew_before and, with force, always reissues. The index
+// is saved before the key and certificate files are written, so a failed
+// write is repaired by the next run. Renewed certificates stay valid until
+// they expire; reissued ones are revoked as superseded.
+func (is *Issuer) Ensure(cn string, now time.Time, force bool) (string, *Issued, error) {
+	action, current, err := is.Plan(cn, now, force)
+	if err != nil || action == ActionKeep {
+		return action, nil, err
+	}
+
+	if is.ca == nil {
+		ca, err := LoadCA(is.cfg.CACert, is.cfg.CAKey)
+		if err != nil {
+			return action, nil, err
+		}
+		is.ca = ca
+	}
+
+	issued, err := is.ca.Issue(cn, is.cfg.KeyType, is.cfg.CertValidity, now)
+	if err != nil {
+		return action, nil, err
+	}
+	if is.idx.Find(issued.Cert.SerialNumber) != nil {
+		return action, nil, errors.New("duplicate serial number")
+	}
+
+	if err := is.idx.Add(issued.Cert); err != nil {
+		return action, nil, err
+	}
+	if action == ActionReissue {
+		current.Revoke(now, ReasonSuperseded)
+	}
+	if err := is.idx.Save(is.cfg.IndexFile); err != nil {
+		return action, nil, fmt.Errorf("failed to write index: %w", err)
+	}
+
+	if err := is.store(cn, issued); err != nil {
+		return action, nil, err
+	}
+	return action, issued, nil
+}
+
+// store writes the certificate and key in the easy-rsa layout
+func (is *Issuer) store(cn string, issued *Issued) error {
+	keyPath, err := KeyPath(is.cfg.Dir, cn)
+	if err != nil {
+		return err
+	}
+	certPath, err := CertPath(is.cfg.Dir, cn)
+	if err != nil {
+		return err
+	}
+
+	serial := strings.ToUpper(issued.Cert.SerialNumber.Text(16))
+	files := []struct {
+		path string
+		data []byte
+		perm os.FileMode
+	}{
+		{filepath.Join(is.cfg.Dir, "certs_by_serial", serial+".pem"), issued.CertPEM, 0644},
+		{keyPath, issued.KeyPEM, 0600},
+		{certPath, issued.CertPEM, 0644},
+	}
+	for _, f := range files {
+		if err := os.MkdirAll(filepath.Dir(f.path), 0700); err != nil {
+			return err
+		}
+		if err := utils.WriteFileAtomic(f.path, f.data, f.perm); err != nil {
+			return fmt.Errorf("failed to write %s: %w", f.path, err)
+		}
+	}
+	return nil
+}
+
+// loadCert reads the first PEM certificate of a file. easy-rsa certificate
+// files may start with a text dump, which is skipped.
+func loadCert(path string) (*x509.Certificate, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return nil, fmt.Errorf("no certificate in %s", path)
+		}
+		if block.Type == "CERTIFICATE" {
+			return x509.ParseCertificate(block.Bytes)
+		}
+	}
+}
+
+// UpdateCRL regenerates the CRL after Ensure revoked reissued certificates
+func (is *Issuer) UpdateCRL(now time.Time) error {
+	if is.ca == nil {
+		return errors.New("no certificate issued")
+	}
+	// An unreadable CRL only restarts the numbering, the new one is complete
+	prev, _ := LoadCRL(is.cfg.CRLFile)
+	crl, err := is.ca.CreateCRL(is.idx, prev, now, is.cfg.CRLValidity)
+	if err != nil {
+		return err
+	}
+	return SaveCRL(is.cfg.CRLFile, crl)
+}
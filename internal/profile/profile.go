@@ -0,0 +1,149 @@
+package profile
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+	"text/template"
+
+	"github.com/tldr-it-stepankutaj/openvpn-client/internal/config"
+	"github.com/tldr-it-stepankutaj/openvpn-client/internal/pki"
+)
+
+// Profile is the data a profile template is rendered with. Key material is
+// PEM (or OpenVPN static key) text without a trailing newline.
+type Profile struct {
+	Username string
+	Remote   string
+	Proto    string // "udp" or "tcp"
+	Port     int
+	CA       string
+	TLSAuth  string // empty unless tls_auth_file is set
+	TLSCrypt string // empty unless tls_crypt_file is set
+	Cert     string // empty unless client_certs is enabled
+	Key      string
+}
+
+// Renderer renders client profiles for users of one server
+type Renderer struct {
+	tmpl   *template.Template
+	base   Profile
+	pkiDir string // per-user certificates and keys, "" = none
+}
+
+// NewRenderer loads the template and the server's CA and TLS keys for the
+// given protocol variant
+func NewRenderer(cfg *config.Config, proto string) (*Renderer, error) {
+	pc := &cfg.Profile
+	if pc.Remote == "" {
+		return nil, fmt.Errorf("profile.remote is required")
+	}
+
+	text := DefaultTemplate
+	if pc.Template != "" {
+		data, err := os.ReadFile(pc.Template)
+		if err != nil {
+			return nil, err
+		}
+		text = string(data)
+	}
+	tmpl, err := template.New("profile").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid profile template: %w", err)
+	}
+
+	r := &Renderer{
+		tmpl: tmpl,
+		base: Profile{Remote: pc.Remote, Proto: proto, Port: pc.UDPPort},
+	}
+	switch proto {
+	case "udp":
+	case "tcp":
+		r.base.Port = pc.TCPPort
+	default:
+		return nil, fmt.Errorf("proto must be 'udp' or 'tcp'")
+	}
+
+	if r.base.CA, err = readBlock(pc.CAFile); err != nil {
+		return nil, err
+	}
+	if pc.TLSCryptFile != "" {
+		if r.base.TLSCrypt, err = readBlock(pc.TLSCryptFile); err != nil {
+			return nil, err
+		}
+	} else if pc.TLSAuthFile != "" {
+		if r.base.TLSAuth, err = readBlock(pc.TLSAuthFile); err != nil {
+			return nil, err
+		}
+	}
+	if pc.ClientCerts {
+		r.pkiDir = cfg.PKI.Dir
+	}
+	return r, nil
+}
+
+// Proto returns the protocol variant of the renderer
+func (r *Renderer) Proto() string {
+	return r.base.Proto
+}
+
+// Render returns the profile of username. The username is also the
+// certificate's common name, so it must pass pki.ValidCN.
+func (r *Renderer) Render(username string) ([]byte, error) {
+	if err := pki.ValidCN(username); err != nil {
+		return nil, err
+	}
+
+	p := r.base
+	p.Username = username
+	if r.pkiDir != "" {
+		certPath, err := pki.CertPath(r.pkiDir, username)
+		if err != nil {
+			return nil, err
+		}
+		keyPath, err := pki.KeyPath(r.pkiDir, username)
+		if err != nil {
+			return nil, err
+		}
+		if p.Cert, err = readBlock(certPath); err != nil {
+			return nil, fmt.Errorf("no client certificate: %w", err)
+		}
+		if p.Key, err = readBlock(keyPath); err != nil {
+			return nil, fmt.Errorf("no client key: %w", err)
+		}
+	}
+
+	var out bytes.Buffer
+	if err := r.tmpl.Execute(&out, &p); err != nil {
+		return nil, fmt.Errorf("failed to render profile: %w", err)
+	}
+	return out.Bytes(), nil
+}
+
+// FileName returns the profile file name of username: <username>.ovpn for
+// UDP and <username>-tcp.ovpn for TCP, like the sample profiles
+func FileName(username, proto string) string {
+	if proto == "tcp" {
+		return username + "-tcp.ovpn"
+	}
+	return username + ".ovpn"
+}
+
+// readBlock reads a PEM or static key file for inlining
+func readBlock(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	block := strings.TrimSpace(strings.ReplaceAll(string(data), "\r\n", "\n"))
+	if !strings.HasPrefix(block, "-----BEGIN ") {
+		// easy-rsa certificates start with a text dump; keep only the PEM part
+		start := strings.Index(block, "\n-----BEGIN ")
+		if start < 0 {
+			return "", fmt.Errorf("%s: no PEM data", path)
+		}
+		block = block[start+1:]
+	}
+	return block, nil
+}
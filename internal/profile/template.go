@@ -0,0 +1,73 @@
+package profile
+
+// DefaultTemplate is the built-in client profile, based on
+// samples/openvpn/client.ovpn and client-tcp.ovpn
+const DefaultTemplate = `# OpenVPN client profile for {{ .Username }}
+# Generated by openvpn-profile - username/password authentication via the API
+{{- if eq .Proto "tcp" }}
+#
+# TCP on port {{ .Port }} - use when UDP is blocked
+{{- end }}
+
+### GENERAL ###
+client
+dev tun
+proto {{ .Proto }}
+remote {{ .Remote }} {{ .Port }}
+nobind
+persist-key
+persist-tun
+
+# CA certificate (server's CA)
+<ca>
+{{ .CA }}
+</ca>
+{{- if .TLSCrypt }}
+
+# TLS-Crypt key
+<tls-crypt>
+{{ .TLSCrypt }}
+</tls-crypt>
+{{- else if .TLSAuth }}
+
+# TLS-Auth key for additional security
+<tls-auth>
+{{ .TLSAuth }}
+</tls-auth>
+key-direction 1
+{{- end }}
+{{- if .Cert }}
+
+# Client certificate and key
+<cert>
+{{ .Cert }}
+</cert>
+<key>
+{{ .Key }}
+</key>
+{{- end }}
+
+# Verify server certificate
+remote-cert-tls server
+
+# Encryption (must match server)
+cipher AES-256-GCM
+
+# Connection settings
+{{- if eq .Proto "tcp" }}
+hand-window 30
+resolv-retry 40
+{{- else }}
+resolv-retry infinite
+{{- end }}
+
+# Security - don't cache credentials in memory
+auth-nocache
+
+# Username/password authentication
+auth-user-pass
+
+# Verbosity (0-9)
+verb 3
+### END GENERAL ###
+`
@@ -0,0 +1,235 @@
+package ratelimit
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"syscall"
+	"time"
+
+	"github.com/tldr-it-stepankutaj/openvpn-client/internal/config"
+)
+
+const (
+	KeyIP   = "ip"
+	KeyUser = "user"
+)
+
+// maxEntries caps the IPs and the usernames tracked in the state file, so
+// failures from many sources cannot grow it without bound
+const maxEntries = 10000
+
+// Limiter is a sliding-window limiter of failed logins keyed by source IP
+// and by username. State is kept in a small JSON file shared by all login
+// processes and protected with flock.
+type Limiter struct {
+	path       string
+	window     time.Duration
+	lockout    time.Duration
+	maxPerIP   int
+	maxPerUser int
+}
+
+// Lockout is an active lockout of a source IP or username
+type Lockout struct {
+	Key   string // KeyIP or KeyUser
+	Value string
+	Until time.Time
+}
+
+// entry holds the recent failures of one IP or username (Unix seconds)
+type entry struct {
+	Failures    []int64 `json:"failures,omitempty"`
+	LockedUntil int64   `json:"locked_until,omitempty"`
+}
+
+type state struct {
+	IPs   map[string]*entry `json:"ips"`
+	Users map[string]*entry `json:"users"`
+}
+
+// New creates a limiter from configuration
+func New(cfg *config.LoginLimitConfig) *Limiter {
+	return &Limiter{
+		path:       cfg.StateFile,
+		window:     cfg.Window,
+		lockout:    cfg.Lockout,
+		maxPerIP:   cfg.MaxFailuresPerIP,
+		maxPerUser: cfg.MaxFailuresPerUser,
+	}
+}
+
+// Enabled reports whether any threshold is configured
+func (l *Limiter) Enabled() bool {
+	return l.maxPerIP > 0 || l.maxPerUser > 0
+}
+
+// Check returns the active lockout of the source IP or username, or nil
+func (l *Limiter) Check(ip, username string) (*Lockout, error) {
+	var lockout *Lockout
+	err := l.update(func(s *state, now time.Time) {
+		if e := s.IPs[ip]; ip != "" && e != nil && e.LockedUntil > now.Unix() {
+			lockout = &Lockout{Key: KeyIP, Value: ip, Until: time.Unix(e.LockedUntil, 0)}
+			return
+		}
+		if e := s.Users[username]; e != nil && e.LockedUntil > now.Unix() {
+			lockout = &Lockout{Key: KeyUser, Value: username, Until: time.Unix(e.LockedUntil, 0)}
+		}
+	})
+	return lockout, err
+}
+
+// Failure records a failed login and returns the lockouts it started
+func (l *Limiter) Failure(ip, username string) ([]Lockout, error) {
+	var started []Lockout
+	err := l.update(func(s *state, now time.Time) {
+		if ip != "" && l.maxPerIP > 0 {
+			if until, ok := l.fail(s.IPs, ip, l.maxPerIP, now); ok {
+				started = append(started, Lockout{Key: KeyIP, Value: ip, Until: until})
+			}
+		}
+		if l.maxPerUser > 0 {
+			if until, ok := l.fail(s.Users, username, l.maxPerUser, now); ok {
+				started = append(started, Lockout{Key: KeyUser, Value: username, Until: until})
+			}
+		}
+	})
+	return started, err
+}
+
+// Success clears the failures of the username. Failures of the source IP
+// are kept, so one valid account does not reset a password spray.
+func (l *Limiter) Success(username string) error {
+	return l.update(func(s *state, _ time.Time) {
+		delete(s.Users, username)
+	})
+}
+
+// fail adds a failure to the entry of key and locks it out once limit
+// failures are within the window
+func (l *Limiter) fail(entries map[string]*entry, key string, limit int, now time.Time) (time.Time, bool) {
+	e := entries[key]
+	if e == nil {
+		e = &entry{}
+		entries[key] = e
+	}
+	e.Failures = append(e.Failures, now.Unix())
+	if len(e.Failures) < limit || e.LockedUntil > now.Unix() {
+		return time.Time{}, false
+	}
+
+	until := now.Add(l.lockout)
+	e.LockedUntil = until.Unix()
+	e.Failures = nil
+	return until, true
+}
+
+// update runs fn on the state while holding an exclusive lock on the
+// state file, prunes expired entries, caps the number of entries and
+// writes the state back
+func (l *Limiter) update(fn func(s *state, now time.Time)) error {
+	f, err := os.OpenFile(l.path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open limiter state: %w", err)
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		return fmt.Errorf("failed to lock limiter state: %w", err)
+	}
+	defer func() {
+		_ = syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+	}()
+
+	s := &state{}
+	if data, err := io.ReadAll(f); err == nil && len(data) > 0 {
+		// A corrupt state file is replaced rather than blocking all logins
+		_ = json.Unmarshal(data, s)
+	}
+	if s.IPs == nil {
+		s.IPs = make(map[string]*entry)
+	}
+	if s.Users == nil {
+		s.Users = make(map[string]*entry)
+	}
+
+	// Prune before fn too, so failures outside the window are never counted
+	now := time.Now()
+	l.prune(s.IPs, now)
+	l.prune(s.Users, now)
+	fn(s, now)
+	l.prune(s.IPs, now)
+	l.prune(s.Users, now)
+	evict(s.IPs, now)
+	evict(s.Users, now)
+
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	if err := f.Truncate(0); err != nil {
+		return fmt.Errorf("failed to write limiter state: %w", err)
+	}
+	if _, err := f.WriteAt(data, 0); err != nil {
+		return fmt.Errorf("failed to write limiter state: %w", err)
+	}
+	return nil
+}
+
+// prune drops failures outside the window and entries without recent
+// failures or an active lockout
+func (l *Limiter) prune(entries map[string]*entry, now time.Time) {
+	cutoff := now.Add(-l.window).Unix()
+	for key, e := range entries {
+		recent := e.Failures[:0]
+		for _, t := range e.Failures {
+			if t > cutoff {
+				recent = append(recent, t)
+			}
+		}
+		e.Failures = recent
+		if len(e.Failures) == 0 && e.LockedUntil <= now.Unix() {
+			delete(entries, key)
+		}
+	}
+}
+
+// evict drops entries beyond maxEntries. Entries without an active lockout
+// go first, oldest last failure first.
+func evict(entries map[string]*entry, now time.Time) {
+	excess := len(entries) - maxEntries
+	if excess <= 0 {
+		return
+	}
+
+	keys := make([]string, 0, len(entries))
+	for key := range entries {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := entries[keys[i]], entries[keys[j]]
+		aLocked, bLocked := a.LockedUntil > now.Unix(), b.LockedUntil > now.Unix()
+		if aLocked != bLocked {
+			return !aLocked
+		}
+		if aLocked {
+			return a.LockedUntil < b.LockedUntil
+		}
+		return a.last() < b.last()
+	})
+	for _, key := range keys[:excess] {
+		delete(entries, key)
+	}
+}
+
+// last returns the time of the latest failure
+func (e *entry) last() int64 {
+	if len(e.Failures) == 0 {
+		return 0
+	}
+	return e.Failures[len(e.Failures)-1]
+}
@@ -0,0 +1,80 @@
+package ratelimit
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/tldr-it-stepankutaj/openvpn-client/internal/config"
+)
+
+func newTestLimiter(t *testing.T) *Limiter {
+	t.Helper()
+	return New(&config.LoginLimitConfig{
+		StateFile:          filepath.Join(t.TempDir(), "limiter.json"),
+		Window:             time.Minute,
+		Lockout:            time.Hour,
+		MaxFailuresPerIP:   3,
+		MaxFailuresPerUser: 3,
+	})
+}
+
+func TestFailureIgnoresExpiredFailures(t *testing.T) {
+	l := newTestLimiter(t)
+
+	// Two failures from before the window must not count towards a lockout
+	old := time.Now().Add(-2 * time.Minute).Unix()
+	data, _ := json.Marshal(state{Users: map[string]*entry{"alice": {Failures: []int64{old, old}}}})
+	if err := os.WriteFile(l.path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	started, err := l.Failure("", "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(started) != 0 {
+		t.Errorf("expired failures started a lockout: %+v", started)
+	}
+}
+
+func TestEvictKeepsLockouts(t *testing.T) {
+	now := time.Now()
+	entries := make(map[string]*entry, maxEntries+10)
+	for i := 0; i < maxEntries+9; i++ {
+		entries[strconv.Itoa(i)] = &entry{Failures: []int64{now.Unix() - int64(i)}}
+	}
+	entries["locked"] = &entry{LockedUntil: now.Add(time.Hour).Unix()}
+
+	evict(entries, now)
+
+	if len(entries) != maxEntries {
+		t.Fatalf("got %d entries, want %d", len(entries), maxEntries)
+	}
+	if entries["locked"] == nil {
+		t.Error("active lockout was evicted")
+	}
+	if entries["0"] == nil || entries[strconv.Itoa(maxEntries+8)] != nil {
+		t.Error("evicted newer failures before older ones")
+	}
+}
+
+func TestFailureLocksOut(t *testing.T) {
+	l := newTestLimiter(t)
+
+	for i := 0; i < 3; i++ {
+		if _, err := l.Failure("192.0.2.1", "bob"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	lockout, err := l.Check("192.0.2.1", "bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lockout == nil || lockout.Key != KeyIP {
+		t.Errorf("got lockout %+v, want IP lockout", lockout)
+	}
+}
@@ -0,0 +1,257 @@
+package session
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	sessionPrefix    = "session-"
+	addressPrefix    = "address-"
+	connectionPrefix = "conn-"
+	lockPrefix       = "lock-"
+)
+
+// ErrNotFound is returned when a session or address record does not exist
+var ErrNotFound = errors.New("not found")
+
+// Session represents an active VPN session stored on disk
+type Session struct {
+	ID          string
+	TrustedIP   string
+	TrustedPort string
+}
+
+// Connection is a single client connection. A common name may have several
+// connections at once, e.g. with duplicate-cn.
+type Connection struct {
+	CommonName  string
+	TrustedIP   string
+	TrustedPort string
+	ConnectedAt time.Time
+}
+
+// RealAddress returns the client's real address as used by the management
+// interface ("ip:port")
+func (c *Connection) RealAddress() string {
+	return c.TrustedIP + ":" + c.TrustedPort
+}
+
+// Store keeps session and address records in the session directory
+type Store struct {
+	dir string
+}
+
+// NewStore creates a new session store rooted at dir
+func NewStore(dir string) *Store {
+	return &Store{dir: dir}
+}
+
+// Save writes the API session of a connection. Sessions are keyed by common
+// name and real address, so connections sharing a common name (duplicate-cn,
+// session limits above 1) keep their own API sessions.
+func (s *Store) Save(commonName string, sess *Session) error {
+	data := fmt.Sprintf("%s\n%s\n%s", sess.ID, sess.TrustedIP, sess.TrustedPort)
+	return os.WriteFile(s.sessionPath(commonName, sess.TrustedIP, sess.TrustedPort), []byte(data), 0600)
+}
+
+// Load reads the API session of the connection of commonName from
+// trustedIP:trustedPort. A session saved by an earlier version under the
+// common name alone is used if it was recorded for the same address.
+func (s *Store) Load(commonName, trustedIP, trustedPort string) (*Session, error) {
+	sess, err := readSession(s.sessionPath(commonName, trustedIP, trustedPort))
+	if !errors.Is(err, ErrNotFound) {
+		return sess, err
+	}
+
+	sess, err = readSession(s.legacySessionPath(commonName))
+	if err != nil {
+		return nil, err
+	}
+	if !sess.matches(trustedIP, trustedPort) {
+		return nil, ErrNotFound
+	}
+	return sess, nil
+}
+
+// Remove deletes the API session of a connection
+func (s *Store) Remove(commonName, trustedIP, trustedPort string) error {
+	if sess, err := readSession(s.legacySessionPath(commonName)); err == nil && sess.matches(trustedIP, trustedPort) {
+		if err := removeFile(s.legacySessionPath(commonName)); err != nil {
+			return err
+		}
+	}
+	return removeFile(s.sessionPath(commonName, trustedIP, trustedPort))
+}
+
+// SessionPath returns the path of the session file of a connection
+func (s *Store) SessionPath(commonName, trustedIP, trustedPort string) string {
+	return s.sessionPath(commonName, trustedIP, trustedPort)
+}
+
+// matches reports whether the session was recorded for the given address.
+// Sessions without an address match any.
+func (sess *Session) matches(trustedIP, trustedPort string) bool {
+	return sess.TrustedIP == "" || (sess.TrustedIP == trustedIP && sess.TrustedPort == trustedPort)
+}
+
+func readSession(path string) (*Session, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	lines := strings.Split(string(data), "\n")
+	sess := &Session{ID: strings.TrimSpace(lines[0])}
+	if len(lines) > 1 {
+		sess.TrustedIP = strings.TrimSpace(lines[1])
+	}
+	if len(lines) > 2 {
+		sess.TrustedPort = strings.TrimSpace(lines[2])
+	}
+	if sess.ID == "" {
+		return nil, fmt.Errorf("invalid session file format")
+	}
+	return sess, nil
+}
+
+// SaveAddress records that address (IP, iroute'd subnet or MAC) belongs to commonName
+func (s *Store) SaveAddress(address, commonName string) error {
+	return os.WriteFile(s.addressPath(address), []byte(commonName+"\n"), 0600)
+}
+
+// LookupAddress returns the common name that owns the given address
+func (s *Store) LookupAddress(address string) (string, error) {
+	data, err := os.ReadFile(s.addressPath(address))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return "", ErrNotFound
+		}
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// RemoveAddress deletes the address record
+func (s *Store) RemoveAddress(address string) error {
+	return removeFile(s.addressPath(address))
+}
+
+// AddConnection records a client connection
+func (s *Store) AddConnection(conn *Connection) error {
+	data := fmt.Sprintf("%s\n%s\n%s\n%d", conn.CommonName, conn.TrustedIP, conn.TrustedPort, conn.ConnectedAt.Unix())
+	return os.WriteFile(s.connectionPath(conn.CommonName, conn.TrustedIP, conn.TrustedPort), []byte(data), 0600)
+}
+
+// Connections returns the recorded connections of a common name, oldest first
+func (s *Store) Connections(commonName string) ([]Connection, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	prefix := connectionPrefix + fileKey(commonName) + "@"
+	var conns []Connection
+	for _, entry := range entries {
+		if !strings.HasPrefix(entry.Name(), prefix) {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(s.dir, entry.Name()))
+		if err != nil {
+			continue
+		}
+		// The prefix may match other common names containing "@"
+		conn, ok := parseConnection(string(data))
+		if !ok || conn.CommonName != commonName {
+			continue
+		}
+		conns = append(conns, *conn)
+	}
+
+	sort.Slice(conns, func(i, j int) bool {
+		return conns[i].ConnectedAt.Before(conns[j].ConnectedAt)
+	})
+	return conns, nil
+}
+
+// RemoveConnection deletes a connection record
+func (s *Store) RemoveConnection(commonName, trustedIP, trustedPort string) error {
+	return removeFile(s.connectionPath(commonName, trustedIP, trustedPort))
+}
+
+// Lock takes an exclusive lock on the connection records of commonName, so
+// concurrent connects of the same user count and record their connections
+// one at a time. The returned function releases the lock.
+func (s *Store) Lock(commonName string) (func(), error) {
+	f, err := os.OpenFile(filepath.Join(s.dir, lockPrefix+fileKey(commonName)), os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open session lock: %w", err)
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		_ = f.Close()
+		return nil, fmt.Errorf("failed to lock sessions: %w", err)
+	}
+	return func() {
+		_ = syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+		_ = f.Close()
+	}, nil
+}
+
+func parseConnection(data string) (*Connection, bool) {
+	lines := strings.Split(data, "\n")
+	if len(lines) < 4 {
+		return nil, false
+	}
+	connectedAt, err := strconv.ParseInt(strings.TrimSpace(lines[3]), 10, 64)
+	if err != nil {
+		return nil, false
+	}
+	return &Connection{
+		CommonName:  lines[0],
+		TrustedIP:   strings.TrimSpace(lines[1]),
+		TrustedPort: strings.TrimSpace(lines[2]),
+		ConnectedAt: time.Unix(connectedAt, 0),
+	}, true
+}
+
+func (s *Store) connectionPath(commonName, trustedIP, trustedPort string) string {
+	return filepath.Join(s.dir, connectionPrefix+fileKey(commonName)+"@"+fileKey(trustedIP)+"_"+fileKey(trustedPort))
+}
+
+func (s *Store) sessionPath(commonName, trustedIP, trustedPort string) string {
+	return filepath.Join(s.dir, sessionPrefix+fileKey(commonName)+"@"+fileKey(trustedIP)+"_"+fileKey(trustedPort))
+}
+
+// legacySessionPath is the session file keyed by common name only
+func (s *Store) legacySessionPath(commonName string) string {
+	return filepath.Join(s.dir, sessionPrefix+fileKey(commonName))
+}
+
+func (s *Store) addressPath(address string) string {
+	return filepath.Join(s.dir, addressPrefix+fileKey(address))
+}
+
+// fileKey makes a value safe for use as a single path component
+func fileKey(value string) string {
+	return strings.NewReplacer("/", "_", "\\", "_", "\x00", "_").Replace(value)
+}
+
+func removeFile(path string) error {
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+package session
+
+import (
+	"errors"
+	"os"
+	"testing"
+)
+
+func TestStoreSessionsPerConnection(t *testing.T) {
+	store := NewStore(t.TempDir())
+
+	first := &Session{ID: "s1", TrustedIP: "192.0.2.1", TrustedPort: "1194"}
+	second := &Session{ID: "s2", TrustedIP: "192.0.2.1", TrustedPort: "50000"}
+	for _, sess := range []*Session{first, second} {
+		if err := store.Save("alice", sess); err != nil {
+			t.Fatalf("Save: %v", err)
+		}
+	}
+
+	if err := store.Remove("alice", "192.0.2.1", "1194"); err != nil {
+		t.Fatalf("Remove: %v", err)
+	}
+	if _, err := store.Load("alice", "192.0.2.1", "1194"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("removed session: got %v, want ErrNotFound", err)
+	}
+	if sess, err := store.Load("alice", "192.0.2.1", "50000"); err != nil || sess.ID != "s2" {
+		t.Errorf("second session: got %+v, %v", sess, err)
+	}
+}
+
+func TestStoreLegacySession(t *testing.T) {
+	store := NewStore(t.TempDir())
+	if err := os.WriteFile(store.legacySessionPath("alice"), []byte("s1\n192.0.2.1\n1194"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := store.Load("alice", "192.0.2.1", "50000"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("other connection: got %v, want ErrNotFound", err)
+	}
+	if sess, err := store.Load("alice", "192.0.2.1", "1194"); err != nil || sess.ID != "s1" {
+		t.Errorf("legacy session: got %+v, %v", sess, err)
+	}
+	if err := store.Remove("alice", "192.0.2.1", "1194"); err != nil {
+		t.Fatalf("Remove: %v", err)
+	}
+	if _, err := os.Stat(store.legacySessionPath("alice")); !os.IsNotExist(err) {
+		t.Errorf("legacy session file not removed: %v", err)
+	}
+}
@@ -0,0 +1,24 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// ValidDiffFormat reports whether format is a -diff-format value: "text"
+// or "json"
+func ValidDiffFormat(format string) bool {
+	return format == "text" || format == "json"
+}
+
+// PrintDiff writes a dry-run diff to w, as indented JSON or as its text form
+func PrintDiff(w io.Writer, diff fmt.Stringer, format string) error {
+	if format == "json" {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(diff)
+	}
+	_, err := fmt.Fprint(w, diff.String())
+	return err
+}
@@ -0,0 +1,36 @@
+package utils
+
+import (
+	"bytes"
+	"testing"
+)
+
+type testDiff struct {
+	Added []string `json:"added"`
+}
+
+func (d testDiff) String() string {
+	return "+ alice\n"
+}
+
+func TestPrintDiff(t *testing.T) {
+	tests := []struct {
+		format string
+		want   string
+	}{
+		{"text", "+ alice\n"},
+		{"json", "{\n  \"added\": [\n    \"alice\"\n  ]\n}\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			var out bytes.Buffer
+			if err := PrintDiff(&out, testDiff{Added: []string{"alice"}}, tt.format); err != nil {
+				t.Fatal(err)
+			}
+			if out.String() != tt.want {
+				t.Errorf("got %q, want %q", out.String(), tt.want)
+			}
+		})
+	}
+}